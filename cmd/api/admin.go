@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/data"
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+func (app *application) listUsersHandler(c *gin.Context) {
+	var input struct {
+		Email       string    `form:"email"`
+		Activated   *bool     `form:"activated"`
+		CreatedFrom time.Time `form:"created_from" time_format:"2006-01-02"`
+		CreatedTo   time.Time `form:"created_to" time_format:"2006-01-02"`
+		data.Filters
+	}
+
+	if err := c.BindQuery(&input); err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	if input.Page == 0 {
+		input.Page = 1
+	}
+	if input.PageSize == 0 {
+		input.PageSize = 20
+	}
+
+	if input.Sort == "" {
+		input.Sort = "id"
+	}
+
+	v := validator.New()
+
+	input.Filters.SortSafelist = []string{"id", "email", "created_at", "-id", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	// The created range is inclusive of whole days, so created_to is moved to the
+	// start of the following day.
+	var createdFrom, createdTo *time.Time
+
+	if !input.CreatedFrom.IsZero() {
+		createdFrom = &input.CreatedFrom
+	}
+
+	if !input.CreatedTo.IsZero() {
+		end := input.CreatedTo.AddDate(0, 0, 1)
+		createdTo = &end
+	}
+
+	users, metadata, err := app.models.User.List(input.Email, input.Activated, createdFrom, createdTo, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"users": users, "meta_data": metadata}, nil)
+}
+
+func (app *application) showUserHandler(c *gin.Context) {
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	access, err := app.userAccess(user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	access["user"] = user
+
+	app.writeJSON(c, http.StatusOK, access, nil)
+}
+
+func (app *application) updateUserActivationHandler(c *gin.Context) {
+	var input struct {
+		Activated *bool `json:"activated"`
+	}
+
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Activated != nil, "activated", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	if !app.checkNotSelf(c, user) {
+		return
+	}
+
+	user.Activated = *input.Activated
+
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"user": user}, nil)
+}
+
+// suspendUserHandler suspends a user and logs them out everywhere. Suspended users
+// can't log in or authenticate until the suspension is lifted.
+func (app *application) suspendUserHandler(c *gin.Context) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	if !app.checkNotSelf(c, user) {
+		return
+	}
+
+	err = app.models.User.SetSuspension(user, true, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteSessionsForUserExcept(user.ID, "")
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.logger.Info("user suspended", "user_id", user.ID, "by", app.contextGetUser(c).ID, "reason", input.Reason)
+
+	app.writeJSON(c, http.StatusOK, envelope{"user": user}, nil)
+}
+
+func (app *application) unsuspendUserHandler(c *gin.Context) {
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.models.User.SetSuspension(user, false, "")
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.logger.Info("user suspension lifted", "user_id", user.ID, "by", app.contextGetUser(c).ID)
+
+	app.writeJSON(c, http.StatusOK, envelope{"user": user}, nil)
+}
+
+// deleteUserTokensHandler forces a user to log out of every session.
+func (app *application) deleteUserTokensHandler(c *gin.Context) {
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.models.Tokens.DeleteSessionsForUserExcept(user.ID, "")
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "all sessions of the user successfully revoked"}, nil)
+}
+
+func (app *application) grantUserPermissionHandler(c *gin.Context) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(codes.Include(input.Code), "code", "must be a known permission code"); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, input.Code)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	access, err := app.userAccess(user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, access, nil)
+}
+
+func (app *application) revokeUserPermissionHandler(c *gin.Context) {
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	code := c.Param("code")
+
+	if code == "users:admin" && !app.checkNotSelf(c, user) {
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	access, err := app.userAccess(user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, access, nil)
+}
+
+// userAccess describes what a user may do: their roles, the permissions granted to
+// them directly, and the effective permissions that result from both.
+func (app *application) userAccess(user *models.User) (envelope, error) {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	granted, err := app.models.Permissions.GetGrantedForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"roles": roles, "granted_permissions": granted, "permissions": permissions}, nil
+}
+
+// readUserParam loads the user identified by the id route parameter. If the user
+// can't be loaded it writes the error response and returns false.
+func (app *application) readUserParam(c *gin.Context) (*models.User, bool) {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return nil, false
+	}
+
+	user, err := app.models.User.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// checkNotSelf stops administrators from deactivating, suspending or demoting their
+// own account, which could leave nobody able to undo it. It writes the error response
+// and returns false if user is the caller.
+func (app *application) checkNotSelf(c *gin.Context, user *models.User) bool {
+	if user.ID == app.contextGetUser(c).ID {
+		v := validator.New()
+		v.AddError("id", "cannot be applied to your own account")
+		app.failedValidationResponse(c, v.Errors)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+func (app *application) createApiKeyHandler(c *gin.Context) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	userPermissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	key := &models.ApiKey{
+		UserID:      user.ID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+
+	if models.ValidateApiKey(v, key, userPermissions); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.ApiKeys.New(key)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/v1/users/me/api-keys/%d", key.ID))
+
+	// This is the only response that ever includes the plaintext key.
+	app.writeJSON(c, http.StatusCreated, envelope{"api_key": key}, nil)
+}
+
+func (app *application) listApiKeysHandler(c *gin.Context) {
+	user := app.contextGetUser(c)
+
+	keys, err := app.models.ApiKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"api_keys": keys}, nil)
+}
+
+func (app *application) deleteApiKeyHandler(c *gin.Context) {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	err = app.models.ApiKeys.DeleteForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+}
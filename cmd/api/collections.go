@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+// listCollectionsHandler searches the public collections. Unlisted and private ones
+// never show up here, not even for their owner.
+func (app *application) listCollectionsHandler(c *gin.Context) {
+	filters, ok := app.readListFilters(c, "-updated_at", "title", "created_at", "updated_at")
+	if !ok {
+		return
+	}
+
+	collections, metadata, err := app.models.Collections.ListPublic(c.Query("title"), filters)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"collections": collections, "meta_data": metadata}, nil)
+}
+
+func (app *application) listCurrentUserCollectionsHandler(c *gin.Context) {
+	filters, ok := app.readListFilters(c, "-updated_at", "title", "created_at", "updated_at")
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	collections, metadata, err := app.models.Collections.GetAllForUser(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"collections": collections, "meta_data": metadata}, nil)
+}
+
+func (app *application) createCollectionHandler(c *gin.Context) {
+	var input struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Visibility  string `json:"visibility"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	collection := &models.Collection{
+		UserID:      &user.ID,
+		Title:       input.Title,
+		Description: input.Description,
+		Visibility:  input.Visibility,
+	}
+
+	if collection.Visibility == "" {
+		collection.Visibility = models.VisibilityPrivate
+	}
+
+	v := validator.New()
+
+	if models.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(collection)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%s", collection.Slug))
+
+	app.writeJSON(c, http.StatusCreated, envelope{"collection": collection}, headers)
+}
+
+// showCollectionHandler returns a collection with its movies in order. Anyone with the
+// slug can see a public or unlisted collection, and moderators can see private ones.
+func (app *application) showCollectionHandler(c *gin.Context) {
+	collection, ok := app.readCollectionParam(c)
+	if !ok {
+		return
+	}
+
+	var err error
+
+	collection.Items, err = app.models.Collections.GetItems(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"collection": collection}, nil)
+}
+
+func (app *application) updateCollectionHandler(c *gin.Context) {
+	var input struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
+	}
+
+	collection, ok := app.readEditableCollectionParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	if input.Title != nil {
+		collection.Title = *input.Title
+	}
+
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+
+	if input.Visibility != nil {
+		collection.Visibility = *input.Visibility
+	}
+
+	v := validator.New()
+
+	if models.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"collection": collection}, nil)
+}
+
+func (app *application) deleteCollectionHandler(c *gin.Context) {
+	collection, ok := app.readEditableCollectionParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.models.Collections.Delete(collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+}
+
+// putCollectionItemHandler adds a movie to the end of a collection, or updates the
+// note of a movie that is already in it.
+func (app *application) putCollectionItemHandler(c *gin.Context) {
+	var input struct {
+		Note string `json:"note"`
+	}
+
+	collection, ok := app.readEditableCollectionParam(c)
+	if !ok {
+		return
+	}
+
+	movie, ok := app.readMovieParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateCollectionNote(v, input.Note); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.PutItem(collection.ID, movie.ID, input.Note)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "movie saved to the collection"}, nil)
+}
+
+func (app *application) deleteCollectionItemHandler(c *gin.Context) {
+	collection, ok := app.readEditableCollectionParam(c)
+	if !ok {
+		return
+	}
+
+	movieID, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	err = app.models.Collections.RemoveItem(collection.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "movie removed from the collection"}, nil)
+}
+
+// reorderCollectionHandler sets the order of the movies in a collection. The request
+// lists the IDs of all of its movies in their new order.
+func (app *application) reorderCollectionHandler(c *gin.Context) {
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+
+	collection, ok := app.readEditableCollectionParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(validator.Unique(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Reorder(collection.ID, input.MovieIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			v.AddError("movie_ids", "must list every movie in the collection exactly once")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	collection.Items, err = app.models.Collections.GetItems(collection.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"collection": collection}, nil)
+}
+
+// readCollectionParam loads the collection identified by the slug route parameter.
+// Private collections the user can't edit are reported as not found, so their slugs
+// can't be confirmed to exist.
+func (app *application) readCollectionParam(c *gin.Context) (*models.Collection, bool) {
+	collection, err := app.models.Collections.GetBySlug(c.Param("slug"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return nil, false
+	}
+
+	permissions, err := app.userPermissions(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return nil, false
+	}
+
+	if !collection.CanView(app.contextGetUser(c).ID, permissions) {
+		app.notFoundResponse(c)
+		return nil, false
+	}
+
+	return collection, true
+}
+
+// readEditableCollectionParam is like readCollectionParam, but only lets the owner of
+// the collection and moderators through.
+func (app *application) readEditableCollectionParam(c *gin.Context) (*models.Collection, bool) {
+	collection, ok := app.readCollectionParam(c)
+	if !ok {
+		return nil, false
+	}
+
+	permissions, err := app.userPermissions(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return nil, false
+	}
+
+	if !collection.CanEdit(app.contextGetUser(c).ID, permissions) {
+		app.notPermittedResponse(c)
+		return nil, false
+	}
+
+	return collection, true
+}
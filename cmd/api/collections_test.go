@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCollectionModeration(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := insertTestUser(t, app, "alice@example.com", "pa55word1234")
+	insertTestUser(t, app, "bob@example.com", "pa55word1234")
+	mod := insertTestUser(t, app, "mod@example.com", "pa55word1234")
+
+	err := app.models.Roles.AddForUser(mod.ID, "moderator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceToken := ts.login(t, "alice@example.com", "pa55word1234")
+	bobToken := ts.login(t, "bob@example.com", "pa55word1234")
+	modToken := ts.login(t, "mod@example.com", "pa55word1234")
+
+	createCollection := func(visibility string) string {
+		t.Helper()
+
+		status, body := ts.do(t, http.MethodPost, "/v1/collections", aliceToken, map[string]string{
+			"title":      "Favourites",
+			"visibility": visibility,
+		})
+		if status != http.StatusCreated {
+			t.Fatalf("create collection: got status %d; want %d; body %v", status, http.StatusCreated, body)
+		}
+
+		collection, _ := body["collection"].(map[string]any)
+		slug, _ := collection["slug"].(string)
+
+		return slug
+	}
+
+	private := createCollection("private")
+
+	status, _ := ts.do(t, http.MethodGet, "/v1/collections/"+private, bobToken, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("another user viewing a private collection: got status %d; want %d", status, http.StatusNotFound)
+	}
+
+	status, _ = ts.do(t, http.MethodGet, "/v1/collections/"+private, modToken, nil)
+	if status != http.StatusOK {
+		t.Errorf("moderator viewing a private collection: got status %d; want %d", status, http.StatusOK)
+	}
+
+	status, _ = ts.do(t, http.MethodPatch, "/v1/collections/"+private, modToken, map[string]string{"title": "Moderated"})
+	if status != http.StatusOK {
+		t.Errorf("moderator editing a private collection: got status %d; want %d", status, http.StatusOK)
+	}
+
+	// A public collection is kept without an owner when its owner's account is deleted.
+	orphaned := createCollection("public")
+
+	err = app.models.User.Delete(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ = ts.do(t, http.MethodDelete, "/v1/collections/"+orphaned, bobToken, nil)
+	if status != http.StatusForbidden {
+		t.Errorf("another user deleting an orphaned collection: got status %d; want %d", status, http.StatusForbidden)
+	}
+
+	status, _ = ts.do(t, http.MethodDelete, "/v1/collections/"+orphaned, modToken, nil)
+	if status != http.StatusOK {
+		t.Errorf("moderator deleting an orphaned collection: got status %d; want %d", status, http.StatusOK)
+	}
+}
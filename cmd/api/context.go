@@ -0,0 +1,137 @@
+package main
+
+import (
+	"github.com/fayazp088/greenlight/internal/jwt"
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// userContextKey is the key under which the authenticated user is stored in the
+// gin context for the lifetime of a request, and tokenContextKey the key for the
+// plaintext token they authenticated with. Requests authenticated with a JWT store
+// its claims under claimsContextKey instead of a token.
+const (
+	userContextKey        = "user"
+	tokenContextKey       = "token"
+	claimsContextKey      = "claims"
+	permissionsContextKey = "permissions"
+	apiKeyContextKey      = "api_key"
+)
+
+// The contextSetUser() method stores the provided User struct in the request context.
+func (app *application) contextSetUser(c *gin.Context, user *models.User) {
+	c.Set(userContextKey, user)
+}
+
+// The contextGetUser() method retrieves the User struct from the request context. The
+// only time that we'll use this helper is when we logically expect there to be a User
+// struct value in the context, and if it doesn't exist it will firmly be an
+// 'unexpected' error, so we panic.
+func (app *application) contextGetUser(c *gin.Context) *models.User {
+	user, ok := c.MustGet(userContextKey).(*models.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// The contextSetToken() method stores the plaintext authentication token that was
+// presented with the request.
+func (app *application) contextSetToken(c *gin.Context, token string) {
+	c.Set(tokenContextKey, token)
+}
+
+// The contextGetToken() method retrieves the plaintext authentication token from the
+// request context. It returns an empty string when the request was authenticated
+// with a JWT rather than an opaque token.
+func (app *application) contextGetToken(c *gin.Context) string {
+	return c.GetString(tokenContextKey)
+}
+
+// The contextSetClaims() method stores the verified claims of a JWT presented with
+// the request.
+func (app *application) contextSetClaims(c *gin.Context, claims *jwt.Claims) {
+	c.Set(claimsContextKey, claims)
+}
+
+// The contextGetClaims() method retrieves the JWT claims from the request context,
+// reporting whether the request was authenticated with a JWT at all.
+func (app *application) contextGetClaims(c *gin.Context) (*jwt.Claims, bool) {
+	value, exists := c.Get(claimsContextKey)
+	if !exists {
+		return nil, false
+	}
+
+	claims, ok := value.(*jwt.Claims)
+	return claims, ok
+}
+
+// The contextSetPermissions() method stores the permission codes of the
+// authenticated user, so they are only resolved once per request.
+func (app *application) contextSetPermissions(c *gin.Context, permissions models.Permissions) {
+	c.Set(permissionsContextKey, permissions)
+}
+
+// The contextGetPermissions() method retrieves the permission codes stored by
+// contextSetPermissions(), reporting whether they have been resolved yet.
+func (app *application) contextGetPermissions(c *gin.Context) (models.Permissions, bool) {
+	value, exists := c.Get(permissionsContextKey)
+	if !exists {
+		return nil, false
+	}
+
+	permissions, ok := value.(models.Permissions)
+	return permissions, ok
+}
+
+// The contextSetApiKey() method stores the API key a request was authenticated with.
+func (app *application) contextSetApiKey(c *gin.Context, key *models.ApiKey) {
+	c.Set(apiKeyContextKey, key)
+}
+
+// The contextGetApiKey() method retrieves the API key from the request context,
+// reporting whether the request was authenticated with one.
+func (app *application) contextGetApiKey(c *gin.Context) (*models.ApiKey, bool) {
+	value, exists := c.Get(apiKeyContextKey)
+	if !exists {
+		return nil, false
+	}
+
+	key, ok := value.(*models.ApiKey)
+	return key, ok
+}
+
+// The loadCurrentUser() method returns the full record of the authenticated user. A
+// user built from JWT claims only carries an ID and activation state, so in that case
+// the record is fetched from the database.
+func (app *application) loadCurrentUser(c *gin.Context) (*models.User, error) {
+	user := app.contextGetUser(c)
+
+	if _, ok := app.contextGetClaims(c); !ok {
+		return user, nil
+	}
+
+	return app.models.User.Get(user.ID)
+}
+
+// The currentSessionFamily() method returns the token family of the session the
+// request was authenticated with, or an empty string if it wasn't authenticated with
+// a session token (for example, with an API key).
+func (app *application) currentSessionFamily(c *gin.Context) (string, error) {
+	if claims, ok := app.contextGetClaims(c); ok {
+		return claims.SessionID, nil
+	}
+
+	token := app.contextGetToken(c)
+	if token == "" {
+		return "", nil
+	}
+
+	current, err := app.models.Tokens.GetUnexpired(models.ScopeAuthentication, token)
+	if err != nil {
+		return "", err
+	}
+
+	return current.FamilyID, nil
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -70,8 +73,52 @@ func (app *application) rateLimitExceededResponse(c *gin.Context) {
 	app.errorResponse(c, http.StatusTooManyRequests, message)
 }
 
+func (app *application) rateLimitExceededRetryResponse(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	app.rateLimitExceededResponse(c)
+}
+
+func (app *application) tooManyLoginAttemptsResponse(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(c, http.StatusTooManyRequests, message)
+}
+
 func (app *application) invalidCredentialsResponse(c *gin.Context) {
 	message :=
 		"invalid authentication credentials"
 	app.errorResponse(c, http.StatusUnauthorized, message)
 }
+
+func (app *application) invalidAuthenticationTokenResponse(c *gin.Context) {
+	c.Header("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(c, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(c *gin.Context) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(c, http.StatusUnauthorized, message)
+}
+
+func (app *application) inactiveAccountResponse(c *gin.Context) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(c, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(c *gin.Context) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(c, http.StatusForbidden, message)
+}
+
+func (app *application) twoFactorUnavailableResponse(c *gin.Context) {
+	message := "two-factor authentication is not available on this server"
+	app.errorResponse(c, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) accountSuspendedResponse(c *gin.Context) {
+	message := "your user account has been suspended"
+	app.errorResponse(c, http.StatusForbidden, message)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/data"
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+// createDataExportHandler queues a background job that collects everything stored
+// about the authenticated user into a ZIP archive and emails them a one-time token to
+// download it with.
+func (app *application) createDataExportHandler(c *gin.Context) {
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.background(func() {
+		err := app.exportUserData(user)
+		if err != nil {
+			app.logger.Error(err.Error(), "user_id", user.ID)
+		}
+	})
+
+	message := "your data export is being prepared and a download link will be emailed to you"
+	app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+}
+
+// exportUserData builds and stores the export archive for the user, then emails them
+// the download token. Only the most recent token can be used.
+func (app *application) exportUserData(user *models.User) error {
+	archive, err := app.buildDataExport(user)
+	if err != nil {
+		return err
+	}
+
+	err = app.models.DataExports.Replace(&models.DataExport{UserID: user.ID, Archive: archive})
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeDataExport, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeDataExport)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"dataExportToken": token.Plaintext,
+	}
+
+	return app.mailer.Send(user.Email, "data_export.tmpl", data)
+}
+
+// buildDataExport returns a ZIP archive with one JSON file per kind of data we hold
+// about the user.
+func (app *application) buildDataExport(user *models.User) ([]byte, error) {
+	tokens, err := app.models.Tokens.GetMetadataForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := app.models.ApiKeys.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews, err := app.models.Reviews.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lists are read as a single page big enough to hold every entry.
+	watchlist, _, err := app.models.Watchlist.GetAllForUser(user.ID, exportFilters("-added_at"))
+	if err != nil {
+		return nil, err
+	}
+
+	watched, _, err := app.models.Watched.GetAllForUser(user.ID, exportFilters("-watched_on"))
+	if err != nil {
+		return nil, err
+	}
+
+	collections, _, err := app.models.Collections.GetAllForUser(user.ID, exportFilters("created_at"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, collection := range collections {
+		collection.Items, err = app.models.Collections.GetItems(collection.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"user.json", envelope{"user": user, "two_factor_enabled": twoFactor.Enabled}},
+		{"tokens.json", envelope{"tokens": tokens}},
+		{"api_keys.json", envelope{"api_keys": apiKeys}},
+		{"permissions.json", envelope{"permissions": permissions, "roles": roles}},
+		{"identities.json", envelope{"identities": identities}},
+		{"reviews.json", envelope{"reviews": reviews}},
+		{"watchlist.json", envelope{"watchlist": watchlist, "watched": watched}},
+		{"collections.json", envelope{"collections": collections}},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		js, err := json.MarshalIndent(file.data, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = w.Write(js)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func exportFilters(sort string) data.Filters {
+	return data.Filters{Page: 1, PageSize: math.MaxInt32, Sort: sort, SortSafelist: []string{sort}}
+}
+
+// downloadDataExportHandler serves an export archive in exchange for the token from
+// the email. The token and the archive are both deleted once downloaded. The token is
+// read from a POST body rather than a link, so that mail scanners following links
+// can't use it up and it never appears in request logs.
+func (app *application) downloadDataExportHandler(c *gin.Context) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	// The export is consumed before anything is sent, so a link that is used twice
+	// concurrently is only honoured once.
+	export, err := app.models.DataExports.Consume(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired download token")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	filename := fmt.Sprintf("greenlight-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/zip", export.Archive)
+}
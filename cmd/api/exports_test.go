@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/models"
+)
+
+func TestDownloadDataExport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := insertTestUser(t, app, "alice@example.com", "pa55word1234")
+
+	err := app.models.DataExports.Replace(&models.DataExport{UserID: alice.ID, Archive: []byte("archive")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(alice.ID, 24*time.Hour, models.ScopeDataExport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A link scanner following a URL with the token must not use it up.
+	status, _ := ts.do(t, http.MethodGet, "/v1/users/export?token="+token.Plaintext, "", nil)
+	if status == http.StatusOK {
+		t.Errorf("GET with the token in the query string: got status %d", status)
+	}
+
+	// The archive isn't JSON, so this request is made without ts.do.
+	res, err := ts.Client().Post(ts.URL+"/v1/users/export", "application/json", strings.NewReader(`{"token": "`+token.Plaintext+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	archive, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusOK || string(archive) != "archive" {
+		t.Fatalf("download: got status %d and body %q; want %d and the archive", res.StatusCode, archive, http.StatusOK)
+	}
+
+	status, _ = ts.do(t, http.MethodPost, "/v1/users/export", "", map[string]string{"token": token.Plaintext})
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("second download: got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+}
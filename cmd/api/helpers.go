@@ -9,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/fayazp088/greenlight/internal/data"
+	"github.com/fayazp088/greenlight/internal/validator"
 	"github.com/gin-gonic/gin"
 )
 
@@ -25,17 +27,78 @@ func (app *application) writeJSON(c *gin.Context, status int, data envelope, hea
 }
 
 func (app *application) readIDParam(c *gin.Context) (int64, error) {
-	idParam := c.Param("id")
+	return app.readInt64Param(c, "id")
+}
+
+// readInt64Param reads a positive integer ID from the named route parameter, for
+// routes with more than one ID such as /v1/movies/:id/reviews/:review_id.
+func (app *application) readInt64Param(c *gin.Context, name string) (int64, error) {
+	param := c.Param(name)
 
-	id, err := strconv.ParseInt(idParam, 10, 64)
+	id, err := strconv.ParseInt(param, 10, 64)
 
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id params")
+		return 0, fmt.Errorf("invalid %s params", name)
 	}
 
 	return id, nil
 }
 
+// readListFilters reads the page, page_size and sort query parameters, allowing the
+// given sort columns in either direction. If they are invalid it writes the error
+// response and returns false.
+func (app *application) readListFilters(c *gin.Context, defaultSort string, sortColumns ...string) (data.Filters, bool) {
+	var filters data.Filters
+
+	if err := c.BindQuery(&filters); err != nil {
+		app.badRequestResponse(c, err)
+		return data.Filters{}, false
+	}
+
+	if filters.Page == 0 {
+		filters.Page = 1
+	}
+	if filters.PageSize == 0 {
+		filters.PageSize = 20
+	}
+
+	if filters.Sort == "" {
+		filters.Sort = defaultSort
+	}
+
+	for _, column := range sortColumns {
+		filters.SortSafelist = append(filters.SortSafelist, column, "-"+column)
+	}
+
+	v := validator.New()
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return data.Filters{}, false
+	}
+
+	return filters, true
+}
+
+// readBoolQuery reads an optional boolean query parameter, defaulting to false. If it
+// isn't a valid boolean it writes a failed validation response and returns false.
+func (app *application) readBoolQuery(c *gin.Context, key string) (bool, bool) {
+	value := c.Query(key)
+	if value == "" {
+		return false, true
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		v := validator.New()
+		v.AddError(key, "must be a boolean value")
+		app.failedValidationResponse(c, v.Errors)
+		return false, false
+	}
+
+	return b, true
+}
+
 func (app *application) readJSON(c *gin.Context, dst any) error {
 	// Define max body size (e.g., 1MB)
 	maxBytes := 1_048_576 // 1MB
@@ -3,14 +3,23 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fayazp088/greenlight/internal/breached"
+	"github.com/fayazp088/greenlight/internal/jwt"
 	"github.com/fayazp088/greenlight/internal/mailer"
 	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/oidc"
+	"github.com/fayazp088/greenlight/internal/throttle"
+	"github.com/fayazp088/greenlight/internal/totp"
 	"github.com/joho/godotenv"
 )
 
@@ -19,7 +28,12 @@ const version = "1.0.0"
 type config struct {
 	port int
 	env  string
-	db   struct {
+
+	// trustedProxies are the addresses whose X-Forwarded-For and X-Real-IP headers
+	// are believed when working out the client IP. None are trusted by default.
+	trustedProxies []string
+
+	db struct {
 		dsn         string
 		maxConns    int
 		minConns    int
@@ -32,6 +46,57 @@ type config struct {
 		enabled bool
 	}
 
+	movies struct {
+		purgeRetention time.Duration
+		purgeInterval  time.Duration
+	}
+
+	auth struct {
+		accessTokenTTL  time.Duration
+		refreshTokenTTL time.Duration
+	}
+
+	jwt struct {
+		enabled   bool
+		algorithm string
+		issuer    string
+		secrets   string
+		keys      string
+	}
+
+	passwords struct {
+		hasher            string
+		bcryptCost        int
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+
+		minLength            int
+		require              string
+		allowPersonalInfo    bool
+		breachedPasswordsDir string
+	}
+
+	login struct {
+		maxAttempts int
+		backoff     time.Duration
+		lockout     time.Duration
+		store       string
+	}
+
+	totp struct {
+		issuer string
+		key    string
+	}
+
+	oidc struct {
+		name         string
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+	}
+
 	smtp struct {
 		host     string
 		port     int
@@ -46,7 +111,15 @@ type application struct {
 	logger *slog.Logger
 	models models.Models
 	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	jwt    *jwt.KeySet
+	totp   *totp.Cipher
+
+	loginThrottle     *throttle.Limiter
+	magicLinkThrottle *throttle.Limiter
+
+	oidcProviders map[string]*oidc.Provider
+
+	wg sync.WaitGroup
 	// validate *validator.Validate
 }
 
@@ -65,6 +138,12 @@ func main() {
 	flag.StringVar(&cfg.env, "env", "dev", "Environment (dev, staging, prod)")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DSN"), "PostgreSQL DSN")
 
+	// The client IP is used for login throttling and shown in session metadata and
+	// lockout emails, so forwarding headers are ignored unless they come from one of
+	// these proxies.
+	var trustedProxies string
+	flag.StringVar(&trustedProxies, "trusted-proxies", "", "Reverse proxy IP addresses or CIDR ranges to trust forwarding headers from (comma-separated)")
+
 	//connection pool settings
 	flag.IntVar(&cfg.db.maxConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.minConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
@@ -74,6 +153,60 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
+	// Deleted movies can be restored until they are purged. A retention of zero keeps
+	// them forever.
+	flag.DurationVar(&cfg.movies.purgeRetention, "movies-purge-retention", 30*24*time.Hour, "How long deleted movies are kept before being purged")
+	flag.DurationVar(&cfg.movies.purgeInterval, "movies-purge-interval", time.Hour, "How often to purge deleted movies")
+
+	flag.DurationVar(&cfg.auth.accessTokenTTL, "auth-access-token-ttl", 15*time.Minute, "Authentication (access) token lifetime")
+	flag.DurationVar(&cfg.auth.refreshTokenTTL, "auth-refresh-token-ttl", 30*24*time.Hour, "Refresh token lifetime")
+
+	// New password hashes use the configured algorithm and parameters. Hashes made
+	// with anything else are upgraded the next time their owner logs in.
+	flag.StringVar(&cfg.passwords.hasher, "password-hasher", "argon2id", "Password hashing algorithm (argon2id|bcrypt)")
+	flag.IntVar(&cfg.passwords.bcryptCost, "password-bcrypt-cost", 12, "bcrypt cost")
+	flag.UintVar(&cfg.passwords.argon2Memory, "password-argon2-memory", 64*1024, "argon2id memory in KiB")
+	flag.UintVar(&cfg.passwords.argon2Iterations, "password-argon2-iterations", 3, "argon2id iterations")
+	flag.UintVar(&cfg.passwords.argon2Parallelism, "password-argon2-parallelism", 2, "argon2id parallelism")
+
+	// Rules for newly chosen passwords. The breached password check is skipped unless
+	// a directory of HIBP-style range files is given.
+	flag.IntVar(&cfg.passwords.minLength, "password-min-length", 8, "Minimum length of new passwords in bytes")
+	flag.StringVar(&cfg.passwords.require, "password-require", "", "Character classes new passwords must contain (comma-separated: lower,upper,digit,symbol)")
+	flag.BoolVar(&cfg.passwords.allowPersonalInfo, "password-allow-personal-info", false, "Allow passwords equal to the user's email address or name")
+	flag.StringVar(&cfg.passwords.breachedPasswordsDir, "password-breached-dir", "", "Directory of SHA-1 range files of breached passwords")
+
+	// Failed logins are counted per email address and per client IP. Each failure
+	// doubles the wait before the next attempt, and reaching the threshold locks the
+	// key out. Use the postgres store when running more than one API instance.
+	flag.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins before a temporary lockout")
+	flag.DurationVar(&cfg.login.backoff, "login-backoff", time.Second, "Wait after the first failed login, doubled for each further failure")
+	flag.DurationVar(&cfg.login.lockout, "login-lockout", 15*time.Minute, "Lockout duration after too many failed logins")
+	flag.StringVar(&cfg.login.store, "login-throttle-store", "memory", "Failed login store (memory|postgres)")
+
+	// Stateless JWT authentication. Signing keys are given as a comma-separated list
+	// with the active key first; the remaining keys are only used for verification, so
+	// a key can be rotated out without invalidating tokens it has already signed.
+	flag.BoolVar(&cfg.jwt.enabled, "jwt-enabled", false, "Issue and accept JWT authentication tokens")
+	flag.StringVar(&cfg.jwt.algorithm, "jwt-alg", jwt.AlgHS256, "JWT signing algorithm (HS256|EdDSA)")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer claim")
+	flag.StringVar(&cfg.jwt.secrets, "jwt-secrets", os.Getenv("JWT_SECRETS"), "HS256 secrets as kid:secret[,kid:secret]")
+	flag.StringVar(&cfg.jwt.keys, "jwt-keys", os.Getenv("JWT_KEYS"), "Ed25519 PEM private key files as kid=path[,kid=path]")
+
+	// TOTP secrets are encrypted at rest with a 32-byte key given in hex. Two-factor
+	// enrollment is unavailable when no key is configured.
+	flag.StringVar(&cfg.totp.issuer, "totp-issuer", "Greenlight", "TOTP issuer shown in authenticator apps")
+	flag.StringVar(&cfg.totp.key, "totp-key", os.Getenv("TOTP_KEY"), "Hex-encoded 32-byte key for encrypting TOTP secrets")
+
+	// Login through an external OpenID Connect provider, such as a corporate SSO. The
+	// redirect URL is where the provider sends users back to with a code, which the
+	// client then posts to /v1/tokens/oidc/{name}. Disabled when no issuer is given.
+	flag.StringVar(&cfg.oidc.name, "oidc-name", "sso", "Name of the OIDC provider used in URLs")
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", os.Getenv("OIDC_ISSUER"), "OIDC issuer URL")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "OIDC client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "OIDC client secret (empty for public clients)")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", os.Getenv("OIDC_REDIRECT_URL"), "OIDC redirect URL registered with the provider")
+
 	// Read the SMTP server configuration settings into the config struct, using the
 	// Mailtrap settings as the default values. IMPORTANT: If you're following along,
 	// make sure to replace the default values for smtp-username and smtp-password
@@ -85,9 +218,41 @@ func main() {
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.net>", "SMTP sender")
 
 	flag.Parse()
+
+	if cfg.movies.purgeRetention > 0 && cfg.movies.purgeInterval <= 0 {
+		logger.Error("movies-purge-interval must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.login.lockout <= 0 {
+		logger.Error("login-lockout must be positive")
+		os.Exit(1)
+	}
+
+	cfg.trustedProxies, err = parseTrustedProxies(trustedProxies)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 	// Initialize validator
 	// validate := validator.New()
 
+	hasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	models.SetPasswordHasher(hasher)
+
+	policy, err := newPasswordPolicy(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	models.SetPasswordPolicy(policy)
+
 	db, err := openDB(cfg)
 
 	if err != nil {
@@ -99,11 +264,48 @@ func main() {
 
 	logger.Info("database connection pool established")
 
+	keySet, err := openJWTKeySet(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	totpCipher, err := openTOTPCipher(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	appModels := models.New(db)
+
+	var loginAttempts, magicLinkRequests throttle.Store
+
+	// A memory store forgets a key once its retention has passed, so each limiter gets
+	// a store that keeps keys for at least as long as the limiter's lockout.
+	switch cfg.login.store {
+	case "memory":
+		loginAttempts = throttle.NewMemoryStore(cfg.login.lockout)
+		magicLinkRequests = throttle.NewMemoryStore(magicLinkLockout)
+	case "postgres":
+		loginAttempts = appModels.LoginAttempts
+		magicLinkRequests = appModels.LoginAttempts
+	default:
+		logger.Error(fmt.Sprintf("unsupported login throttle store %q", cfg.login.store))
+		os.Exit(1)
+	}
+
 	app := application{
 		config: cfg,
 		logger: logger,
-		models: models.New(db),
+		models: appModels,
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		jwt:    keySet,
+		totp:   totpCipher,
+
+		loginThrottle:     throttle.NewLimiter(loginAttempts, cfg.login.maxAttempts, cfg.login.backoff, cfg.login.lockout),
+		magicLinkThrottle: throttle.NewLimiter(magicLinkRequests, magicLinkMaxRequests, magicLinkBackoff, magicLinkLockout),
+
+		oidcProviders: openOIDCProviders(cfg),
 		// validate: validate,
 	}
 
@@ -138,3 +340,128 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// openJWTKeySet loads the JWT signing keys, returning a nil key set when JWT mode is
+// disabled.
+func openJWTKeySet(cfg config) (*jwt.KeySet, error) {
+	if !cfg.jwt.enabled {
+		return nil, nil
+	}
+
+	switch cfg.jwt.algorithm {
+	case jwt.AlgHS256:
+		return jwt.NewHMACKeySet(cfg.jwt.issuer, cfg.jwt.secrets)
+	case jwt.AlgEdDSA:
+		return jwt.NewEd25519KeySet(cfg.jwt.issuer, cfg.jwt.keys)
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", cfg.jwt.algorithm)
+	}
+}
+
+// openTOTPCipher decodes the TOTP encryption key, returning a nil cipher when no key
+// is configured.
+func openTOTPCipher(cfg config) (*totp.Cipher, error) {
+	if cfg.totp.key == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(cfg.totp.key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP key: %w", err)
+	}
+
+	return totp.NewCipher(key)
+}
+
+// openOIDCProviders returns the configured identity providers by name. There is at
+// most one for now, but routes and stored identities are already keyed by name.
+func openOIDCProviders(cfg config) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+
+	if cfg.oidc.issuer != "" {
+		providers[cfg.oidc.name] = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+		}, nil)
+	}
+
+	return providers
+}
+
+// parseTrustedProxies splits a comma-separated list of IP addresses and CIDR ranges,
+// rejecting any entry that is neither.
+func parseTrustedProxies(list string) ([]string, error) {
+	var proxies []string
+
+	if list == "" {
+		return proxies, nil
+	}
+
+	for _, proxy := range strings.Split(list, ",") {
+		proxy = strings.TrimSpace(proxy)
+
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+		}
+
+		proxies = append(proxies, proxy)
+	}
+
+	return proxies, nil
+}
+
+func newPasswordHasher(cfg config) (models.PasswordHasher, error) {
+	switch cfg.passwords.hasher {
+	case "argon2id":
+		return models.Argon2idHasher{
+			Memory:      uint32(cfg.passwords.argon2Memory),
+			Iterations:  uint32(cfg.passwords.argon2Iterations),
+			Parallelism: uint8(cfg.passwords.argon2Parallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+		}, nil
+	case "bcrypt":
+		return models.BcryptHasher{Cost: cfg.passwords.bcryptCost}, nil
+	default:
+		return nil, fmt.Errorf("unsupported password hasher %q", cfg.passwords.hasher)
+	}
+}
+
+func newPasswordPolicy(cfg config) (models.PasswordPolicy, error) {
+	policy := models.PasswordPolicy{
+		MinLength:            cfg.passwords.minLength,
+		DisallowPersonalInfo: !cfg.passwords.allowPersonalInfo,
+	}
+
+	if cfg.passwords.require != "" {
+		for _, class := range strings.Split(cfg.passwords.require, ",") {
+			switch strings.TrimSpace(class) {
+			case "lower":
+				policy.RequireLower = true
+			case "upper":
+				policy.RequireUpper = true
+			case "digit":
+				policy.RequireDigit = true
+			case "symbol":
+				policy.RequireSymbol = true
+			default:
+				return models.PasswordPolicy{}, fmt.Errorf("unknown password character class %q", class)
+			}
+		}
+	}
+
+	if cfg.passwords.breachedPasswordsDir != "" {
+		breachedPasswords, err := breached.NewRangeDir(cfg.passwords.breachedPasswordsDir)
+		if err != nil {
+			return models.PasswordPolicy{}, err
+		}
+
+		policy.Breached = breachedPasswords
+	}
+
+	return policy, nil
+}
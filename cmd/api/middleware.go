@@ -1,14 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
+	playgroundvalidator "github.com/go-playground/validator/v10"
 	"golang.org/x/time/rate"
 )
 
@@ -39,7 +44,7 @@ func (app *application) inputValidation() gin.HandlerFunc {
 		if len(c.Errors) > 0 {
 			for _, err := range c.Errors {
 				// Check if it's a validation error
-				if validationErr, ok := err.Err.(validator.ValidationErrors); ok {
+				if validationErr, ok := err.Err.(playgroundvalidator.ValidationErrors); ok {
 					// Format the validation error into a structured array response
 					var errorMessages []inputValidationErrors
 					for _, fieldErr := range validationErr {
@@ -139,3 +144,276 @@ func (app *application) rateLimiter() gin.HandlerFunc {
 		}
 	}
 }
+
+func (app *application) authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Add the "Vary: Authorization" header to the response. This indicates to any
+		// caches that the response may vary based on the value of the Authorization
+		// or X-API-Key headers in the request.
+		c.Writer.Header().Add("Vary", "Authorization")
+		c.Writer.Header().Add("Vary", "X-API-Key")
+
+		// Machine clients may send their API key in a dedicated header instead of
+		// the Authorization header.
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			app.authenticateApiKey(c, apiKey)
+			return
+		}
+
+		authorizationHeader := c.GetHeader("Authorization")
+
+		// If there is no Authorization header found, add the AnonymousUser to the
+		// request context and call the next handler in the chain.
+		if authorizationHeader == "" {
+			app.contextSetUser(c, models.AnonymousUser)
+			c.Next()
+			return
+		}
+
+		// Otherwise, we expect the value of the Authorization header to be in the format
+		// "Bearer <token>" or "ApiKey <key>".
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || (headerParts[0] != "Bearer" && headerParts[0] != "ApiKey") {
+			app.invalidAuthenticationTokenResponse(c)
+			c.Abort()
+			return
+		}
+
+		if headerParts[0] == "ApiKey" {
+			app.authenticateApiKey(c, headerParts[1])
+			return
+		}
+
+		token := headerParts[1]
+
+		// In JWT mode a token with three dot-separated segments is verified locally
+		// against the configured keys, without touching the database.
+		if app.jwt != nil && strings.Count(token, ".") == 2 {
+			app.authenticateJWT(c, token)
+			return
+		}
+
+		v := validator.New()
+
+		if models.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(c)
+			c.Abort()
+			return
+		}
+
+		user, err := app.models.User.GetForToken(models.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(c)
+			default:
+				app.serverErrorResponse(c, err)
+			}
+			c.Abort()
+			return
+		}
+
+		if user.IsSuspended() {
+			app.accountSuspendedResponse(c)
+			c.Abort()
+			return
+		}
+
+		err = app.models.Tokens.Touch(models.HashToken(token))
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			c.Abort()
+			return
+		}
+
+		app.contextSetUser(c, user)
+		app.contextSetToken(c, token)
+
+		c.Next()
+	}
+}
+
+// authenticateJWT() verifies a JWT and builds the request user from its claims. The
+// user only carries the ID and activation state from the token, and the permissions
+// in the claims are stored so requirePermission() needn't look them up. Because
+// nothing is looked up, a suspension only takes effect once the token expires; the
+// suspension revokes the refresh tokens so it can't be renewed.
+func (app *application) authenticateJWT(c *gin.Context, token string) {
+	claims, err := app.jwt.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(c)
+		c.Abort()
+		return
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		app.invalidAuthenticationTokenResponse(c)
+		c.Abort()
+		return
+	}
+
+	user := &models.User{
+		ID:        id,
+		Activated: claims.Activated,
+	}
+
+	app.contextSetUser(c, user)
+	app.contextSetClaims(c, claims)
+	app.contextSetPermissions(c, claims.Permissions)
+
+	c.Next()
+}
+
+// authenticateApiKey() resolves an API key to its owner. The request is granted only
+// those of the key's permissions that the owner still holds, so revoking a permission
+// from a user also takes it away from their keys.
+func (app *application) authenticateApiKey(c *gin.Context, keyPlaintext string) {
+	v := validator.New()
+
+	if models.ValidateApiKeyPlaintext(v, keyPlaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(c)
+		c.Abort()
+		return
+	}
+
+	key, err := app.models.ApiKeys.GetForPlaintext(keyPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		c.Abort()
+		return
+	}
+
+	user, err := app.models.User.Get(key.UserID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		c.Abort()
+		return
+	}
+
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(c)
+		c.Abort()
+		return
+	}
+
+	userPermissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		c.Abort()
+		return
+	}
+
+	permissions := models.Permissions{}
+	for _, code := range key.Permissions {
+		if userPermissions.Include(code) {
+			permissions = append(permissions, code)
+		}
+	}
+
+	app.contextSetUser(c, user)
+	app.contextSetApiKey(c, key)
+	app.contextSetPermissions(c, permissions)
+
+	c.Next()
+}
+
+// requireAuthenticatedUser() checks that a user is not anonymous.
+func (app *application) requireAuthenticatedUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := app.contextGetUser(c)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(c)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requireActivatedUser() checks that a user is both authenticated and activated.
+func (app *application) requireActivatedUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := app.contextGetUser(c)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(c)
+			c.Abort()
+			return
+		}
+
+		if !user.Activated {
+			app.inactiveAccountResponse(c)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requireUserCredentials() rejects requests authenticated with an API key, for
+// routes (like managing API keys) that only the human account holder may use.
+func (app *application) requireUserCredentials() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := app.contextGetApiKey(c); ok {
+			app.notPermittedResponse(c)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// requirePermission() checks that the activated user holds the given permission
+// code, either directly or through one of their roles. The effective permissions are
+// resolved once and cached in the request context for any later checks. Mount it
+// after requireActivatedUser() so anonymous and inactive users are rejected with the
+// right response first.
+func (app *application) requirePermission(code string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, err := app.userPermissions(c)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			c.Abort()
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(c)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// userPermissions returns the effective permissions of the authenticated user,
+// loading them on first use and caching them in the request context. It is shared by
+// requirePermission() and by handlers whose checks depend on the request, such as
+// whether a user may delete someone else's review or see deleted movies, so the
+// permissions are only ever resolved in one place.
+func (app *application) userPermissions(c *gin.Context) (models.Permissions, error) {
+	permissions, ok := app.contextGetPermissions(c)
+	if ok {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(app.contextGetUser(c).ID)
+	if err != nil {
+		return nil, err
+	}
+
+	app.contextSetPermissions(c, permissions)
+
+	return permissions, nil
+}
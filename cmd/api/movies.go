@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/fayazp088/greenlight/internal/data"
 	"github.com/fayazp088/greenlight/internal/models"
@@ -143,7 +146,18 @@ func (app *application) showMovieHandler(c *gin.Context) {
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	includeDeleted, ok := app.readIncludeDeleted(c)
+	if !ok {
+		return
+	}
+
+	var movie *models.Movie
+
+	if includeDeleted {
+		movie, err = app.models.Movies.GetIncludingDeleted(id)
+	} else {
+		movie, err = app.models.Movies.Get(id)
+	}
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -154,6 +168,12 @@ func (app *application) showMovieHandler(c *gin.Context) {
 		return
 	}
 
+	movie.Credits, err = app.models.Credits.GetAllForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
 	app.writeJSON(c, http.StatusOK, envelope{"movie": movie}, nil)
 }
 
@@ -168,22 +188,58 @@ func (app *application) deleteMovieHandler(c *gin.Context) {
 	err = app.models.Movies.Delete(id)
 
 	if err != nil {
-		app.errorResponse(c, http.StatusInternalServerError, err)
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
 		return
 	}
 
 	app.writeJSON(c, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 }
 
+// restoreMovieHandler brings back a soft deleted movie that hasn't been purged yet.
+func (app *application) restoreMovieHandler(c *gin.Context) {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	err = app.models.Movies.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"movie": movie}, nil)
+}
+
 func (app *application) listMoviesHandler(c *gin.Context) {
 	var input struct {
-		Title  string   `form:"title"`
-		Genres []string `form:"genres"`
+		Title    string   `form:"title"`
+		Genres   []string `form:"genres"`
+		Director string   `form:"director"`
+		Actor    string   `form:"actor"`
+		Writer   string   `form:"writer"`
 		data.Filters
 	}
 
 	if err := c.BindQuery(&input); err != nil {
-		app.serverErrorResponse(c, err)
+		app.badRequestResponse(c, err)
 		return
 	}
 
@@ -200,7 +256,7 @@ func (app *application) listMoviesHandler(c *gin.Context) {
 
 	v := validator.New()
 
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "rating", "-id", "-title", "-year", "-runtime", "-rating"}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(c, v.Errors)
@@ -211,7 +267,47 @@ func (app *application) listMoviesHandler(c *gin.Context) {
 		input.Genres = []string{}
 	}
 
-	movies, metaData, err := app.models.Movies.List(input.Title, input.Genres, input.Filters)
+	excludeWatched, ok := app.readBoolQuery(c, "exclude_watched")
+	if !ok {
+		return
+	}
+
+	// Watched history is personal, so exclude_watched always refers to the
+	// authenticated user.
+	var excludeWatchedBy int64
+	if excludeWatched {
+		excludeWatchedBy = app.contextGetUser(c).ID
+	}
+
+	// People can be given by ID or by name, e.g. ?director=42 or ?actor=keanu.
+	var credits []models.CreditFilter
+
+	for role, person := range map[string]string{
+		models.CreditRoleDirector: input.Director,
+		models.CreditRoleActor:    input.Actor,
+		models.CreditRoleWriter:   input.Writer,
+	} {
+		if person == "" {
+			continue
+		}
+
+		credit := models.CreditFilter{Role: role}
+
+		if id, err := strconv.ParseInt(person, 10, 64); err == nil && id > 0 {
+			credit.PersonID = id
+		} else {
+			credit.Name = person
+		}
+
+		credits = append(credits, credit)
+	}
+
+	includeDeleted, ok := app.readIncludeDeleted(c)
+	if !ok {
+		return
+	}
+
+	movies, metaData, err := app.models.Movies.List(input.Title, input.Genres, credits, excludeWatchedBy, includeDeleted, input.Filters)
 
 	if err != nil {
 		app.serverErrorResponse(c, err)
@@ -220,3 +316,48 @@ func (app *application) listMoviesHandler(c *gin.Context) {
 
 	app.writeJSON(c, http.StatusOK, envelope{"movies": movies, "meta_data": metaData}, nil)
 }
+
+// readIncludeDeleted reads the include_deleted query parameter, which only
+// administrators may set. If it is invalid or not permitted it writes the error
+// response and returns false.
+func (app *application) readIncludeDeleted(c *gin.Context) (bool, bool) {
+	includeDeleted, ok := app.readBoolQuery(c, "include_deleted")
+	if !ok || !includeDeleted {
+		return false, ok
+	}
+
+	permissions, err := app.userPermissions(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return false, false
+	}
+
+	if !permissions.Include("users:admin") {
+		app.notPermittedResponse(c)
+		return false, false
+	}
+
+	return true, true
+}
+
+// purgeDeletedMovies permanently deletes soft deleted movies once they are older than
+// the retention period, checking at every interval until ctx is cancelled.
+func (app *application) purgeDeletedMovies(ctx context.Context) {
+	ticker := time.NewTicker(app.config.movies.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := app.models.Movies.Purge(time.Now().Add(-app.config.movies.purgeRetention))
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if purged > 0 {
+			app.logger.Info("purged deleted movies", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
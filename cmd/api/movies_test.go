@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestListMoviesQueryParameters(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	insertTestUser(t, app, "alice@example.com", "pa55word1234")
+	token := ts.login(t, "alice@example.com", "pa55word1234")
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"?exclude_watched=true", http.StatusOK},
+		{"?exclude_watched=0", http.StatusOK},
+		{"?exclude_watched=yes", http.StatusUnprocessableEntity},
+		{"?include_deleted=maybe", http.StatusUnprocessableEntity},
+		{"?page=abc", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		status, body := ts.do(t, http.MethodGet, "/v1/movies"+tt.query, token, nil)
+		if status != tt.want {
+			t.Errorf("GET /v1/movies%s: got status %d; want %d; body %v", tt.query, status, tt.want, body)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/oidc"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errUnverifiedIdentityEmail = errors.New("identity provider has not verified the email address")
+	errInactiveIdentityUser    = errors.New("user matching the identity is not activated")
+)
+
+// createOIDCAuthorizationHandler starts a login with an external identity provider. It
+// returns the provider URL the client should send the user to; the provider then
+// redirects back to the configured redirect URL with a code and the state.
+func (app *application) createOIDCAuthorizationHandler(c *gin.Context) {
+	name := c.Param("provider")
+
+	provider, ok := app.oidcProviders[name]
+	if !ok {
+		app.notFoundResponse(c)
+		return
+	}
+
+	login := &models.OIDCLogin{Provider: name}
+
+	state, err := oidc.RandomString()
+	if err == nil {
+		login.Nonce, err = oidc.RandomString()
+	}
+	if err == nil {
+		login.CodeVerifier, err = oidc.RandomString()
+	}
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(c.Request.Context(), state, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.Identities.InsertLogin(state, login, 10*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"authorization_url": authorizationURL}, nil)
+}
+
+// createOIDCAuthenticationTokenHandler completes a login with an external identity
+// provider. The code from the redirect is exchanged for an ID token, whose subject is
+// mapped to a user, and the user then gets the same tokens as a password login.
+func (app *application) createOIDCAuthenticationTokenHandler(c *gin.Context) {
+	name := c.Param("provider")
+
+	provider, ok := app.oidcProviders[name]
+	if !ok {
+		app.notFoundResponse(c)
+		return
+	}
+
+	var input struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(input.State != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	login, err := app.models.Identities.ConsumeLogin(name, input.State)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired state")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	idToken, err := provider.Exchange(c.Request.Context(), input.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchangeFailed), errors.Is(err, oidc.ErrInvalidIDToken):
+			app.logError(c, err)
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	user, err := app.userForIdentity(name, idToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnverifiedIdentityEmail):
+			app.errorResponse(c, http.StatusForbidden, "your identity provider has not verified your email address")
+		case errors.Is(err, errInactiveIdentityUser):
+			app.inactiveAccountResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.completeLogin(c, user)
+}
+
+// userForIdentity returns the user linked to the subject of an ID token. Unknown
+// subjects are linked to the user with the same email address, or to a new activated
+// user, but only when the provider says it has verified that address. An existing
+// user who isn't activated is left alone rather than linked: they may never have
+// activated, but an administrator may also have deactivated them on purpose, and a
+// login mustn't undo that.
+func (app *application) userForIdentity(provider string, idToken *oidc.IDToken) (*models.User, error) {
+	identity, err := app.models.Identities.GetForLogin(provider, idToken.Subject, idToken.Email)
+	if err == nil {
+		return app.models.User.Get(identity.UserID)
+	}
+
+	if !errors.Is(err, models.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if idToken.Email == "" || !bool(idToken.EmailVerified) {
+		return nil, errUnverifiedIdentityEmail
+	}
+
+	identity = &models.Identity{
+		Provider: provider,
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	}
+
+	user, err := app.models.User.GetByEmail(idToken.Email)
+	switch {
+	case err == nil:
+		if !user.Activated {
+			return nil, errInactiveIdentityUser
+		}
+
+		identity.UserID = user.ID
+
+		err = app.models.Identities.Insert(identity)
+		if err != nil && !errors.Is(err, models.ErrDuplicateIdentity) {
+			return nil, err
+		}
+
+		return user, nil
+	case errors.Is(err, models.ErrRecordNotFound):
+		return app.insertIdentityUser(identity, idToken.Name)
+	default:
+		return nil, err
+	}
+}
+
+// insertIdentityUser creates an activated user along with their identity, for a
+// first-time login through an identity provider. The user gets a random password,
+// which they can replace through the password reset flow if they ever want to log in
+// without the provider.
+func (app *application) insertIdentityUser(identity *models.Identity, name string) (*models.User, error) {
+	if name == "" {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	user := &models.User{
+		Name:      name,
+		Email:     identity.Email,
+		Activated: true,
+	}
+
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+
+	if models.ValidateUser(v, user); !v.Valid() {
+		return nil, errors.New("identity provider returned an invalid user profile")
+	}
+
+	err = app.models.Identities.InsertWithUser(identity, user, "viewer")
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
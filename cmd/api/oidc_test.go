@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/oidc"
+)
+
+func TestUserForIdentity(t *testing.T) {
+	app := newTestApplication(t)
+
+	alice := insertTestUser(t, app, "alice@example.com", "pa55word1234")
+
+	t.Run("links a verified email to the existing user", func(t *testing.T) {
+		user, err := app.userForIdentity("sso", &oidc.IDToken{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user.ID != alice.ID {
+			t.Errorf("got user %d; want %d", user.ID, alice.ID)
+		}
+
+		identities, err := app.models.Identities.GetAllForUser(alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(identities) != 1 || identities[0].Provider != "sso" || identities[0].Subject != "alice-sub" {
+			t.Errorf("got identities %+v; want one for sso/alice-sub", identities)
+		}
+	})
+
+	t.Run("finds a linked user by subject after an email change", func(t *testing.T) {
+		user, err := app.userForIdentity("sso", &oidc.IDToken{Subject: "alice-sub", Email: "alice@corp.example.com", EmailVerified: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user.ID != alice.ID {
+			t.Errorf("got user %d; want %d", user.ID, alice.ID)
+		}
+	})
+
+	t.Run("refuses an unverified email", func(t *testing.T) {
+		_, err := app.userForIdentity("sso", &oidc.IDToken{Subject: "mallory-sub", Email: "alice@example.com"})
+		if !errors.Is(err, errUnverifiedIdentityEmail) {
+			t.Errorf("got error %v; want %v", err, errUnverifiedIdentityEmail)
+		}
+	})
+
+	t.Run("refuses to link and reactivate an inactive user", func(t *testing.T) {
+		bob := insertTestUser(t, app, "bob@example.com", "pa55word1234")
+
+		bob.Activated = false
+
+		err := app.models.User.Update(bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = app.userForIdentity("sso", &oidc.IDToken{Subject: "bob-sub", Email: "bob@example.com", EmailVerified: true})
+		if !errors.Is(err, errInactiveIdentityUser) {
+			t.Errorf("got error %v; want %v", err, errInactiveIdentityUser)
+		}
+
+		bob, err = app.models.User.Get(bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bob.Activated {
+			t.Error("inactive user was activated by an identity provider login")
+		}
+
+		identities, err := app.models.Identities.GetAllForUser(bob.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(identities) != 0 {
+			t.Errorf("got identities %+v; want none", identities)
+		}
+	})
+
+	t.Run("creates a user for an unknown email", func(t *testing.T) {
+		user, err := app.userForIdentity("sso", &oidc.IDToken{Subject: "carol-sub", Email: "carol@example.com", EmailVerified: true, Name: "Carol"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !user.Activated || user.Name != "Carol" {
+			t.Errorf("got user %+v; want activated user named Carol", user)
+		}
+
+		identity, err := app.models.Identities.GetForLogin("sso", "carol-sub", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if identity.UserID != user.ID {
+			t.Errorf("identity linked to user %d; want %d", identity.UserID, user.ID)
+		}
+
+		roles, err := app.models.Roles.GetAllForUser(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(roles) != 1 || roles[0] != "viewer" {
+			t.Errorf("got roles %v; want [viewer]", roles)
+		}
+	})
+
+	t.Run("creates nothing when the identity can't be stored", func(t *testing.T) {
+		// carol-sub is already linked, so inserting it again fails after the user row
+		// has been written, and the transaction must take the user with it.
+		identity := &models.Identity{Provider: "sso", Subject: "carol-sub", Email: "dave@example.com"}
+
+		_, err := app.insertIdentityUser(identity, "Dave")
+		if !errors.Is(err, models.ErrDuplicateIdentity) {
+			t.Fatalf("got error %v; want %v", err, models.ErrDuplicateIdentity)
+		}
+
+		_, err = app.models.User.GetByEmail("dave@example.com")
+		if !errors.Is(err, models.ErrRecordNotFound) {
+			t.Errorf("got error %v looking up the user; want %v", err, models.ErrRecordNotFound)
+		}
+	})
+}
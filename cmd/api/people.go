@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+func (app *application) listPeopleHandler(c *gin.Context) {
+	filters, ok := app.readListFilters(c, "name", "id", "name", "birth_year")
+	if !ok {
+		return
+	}
+
+	people, metadata, err := app.models.People.List(c.Query("name"), filters)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"people": people, "meta_data": metadata}, nil)
+}
+
+func (app *application) createPersonHandler(c *gin.Context) {
+	var input struct {
+		Name      string `json:"name"`
+		BirthYear *int32 `json:"birth_year"`
+		Bio       string `json:"bio"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	person := &models.Person{
+		Name:      input.Name,
+		BirthYear: input.BirthYear,
+		Bio:       input.Bio,
+	}
+
+	v := validator.New()
+
+	if models.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.People.Insert(person)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/people/%d", person.ID))
+
+	app.writeJSON(c, http.StatusCreated, envelope{"person": person}, headers)
+}
+
+// showPersonHandler returns a person together with their credits, newest movie first.
+func (app *application) showPersonHandler(c *gin.Context) {
+	person, ok := app.readPersonParam(c)
+	if !ok {
+		return
+	}
+
+	credits, err := app.models.Credits.GetAllForPerson(person.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"person": person, "credits": credits}, nil)
+}
+
+func (app *application) updatePersonHandler(c *gin.Context) {
+	var input struct {
+		Name      *string `json:"name"`
+		BirthYear *int32  `json:"birth_year"`
+		Bio       *string `json:"bio"`
+	}
+
+	person, ok := app.readPersonParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	if input.Name != nil {
+		person.Name = *input.Name
+	}
+
+	if input.BirthYear != nil {
+		person.BirthYear = input.BirthYear
+	}
+
+	if input.Bio != nil {
+		person.Bio = *input.Bio
+	}
+
+	v := validator.New()
+
+	if models.ValidatePerson(v, person); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.People.Update(person)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"person": person}, nil)
+}
+
+func (app *application) deletePersonHandler(c *gin.Context) {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	err = app.models.People.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "person successfully deleted"}, nil)
+}
+
+// createCreditHandler credits a person on a movie as its director, an actor or a
+// writer.
+func (app *application) createCreditHandler(c *gin.Context) {
+	var input struct {
+		PersonID     int64  `json:"person_id"`
+		Role         string `json:"role"`
+		Character    string `json:"character"`
+		BillingOrder int32  `json:"billing_order"`
+	}
+
+	movie, ok := app.readMovieParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	credit := &models.Credit{
+		MovieID:      movie.ID,
+		PersonID:     input.PersonID,
+		Role:         input.Role,
+		Character:    input.Character,
+		BillingOrder: input.BillingOrder,
+	}
+
+	v := validator.New()
+
+	if models.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	person, err := app.models.People.Get(credit.PersonID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("person_id", "must be an existing person")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	credit.PersonName = person.Name
+
+	err = app.models.Credits.Insert(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateCredit):
+			v.AddError("person_id", "is already credited in this role")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusCreated, envelope{"credit": credit}, nil)
+}
+
+func (app *application) deleteCreditHandler(c *gin.Context) {
+	movieID, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	id, err := app.readInt64Param(c, "credit_id")
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	err = app.models.Credits.DeleteForMovie(id, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "credit successfully deleted"}, nil)
+}
+
+// readPersonParam loads the person identified by the id route parameter. If the
+// person can't be loaded it writes the error response and returns false.
+func (app *application) readPersonParam(c *gin.Context) (*models.Person, bool) {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return nil, false
+	}
+
+	person, err := app.models.People.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return nil, false
+	}
+
+	return person, true
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+func (app *application) listReviewsHandler(c *gin.Context) {
+	movie, ok := app.readMovieParam(c)
+	if !ok {
+		return
+	}
+
+	filters, ok := app.readListFilters(c, "-created_at", "id", "created_at", "rating")
+	if !ok {
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(movie.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"reviews": reviews, "meta_data": metadata}, nil)
+}
+
+// createReviewHandler adds the authenticated user's review of a movie. Each user can
+// review a movie once; later changes go through updateReviewHandler.
+func (app *application) createReviewHandler(c *gin.Context) {
+	var input struct {
+		Rating int16  `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	movie, ok := app.readMovieParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	review := &models.Review{
+		MovieID: movie.ID,
+		UserID:  &user.ID,
+		Rating:  input.Rating,
+		Body:    input.Body,
+	}
+
+	v := validator.New()
+
+	if models.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateReview):
+			v.AddError("movie", "you have already reviewed this movie")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/reviews/%d", movie.ID, review.ID))
+
+	app.writeJSON(c, http.StatusCreated, envelope{"review": review}, headers)
+}
+
+func (app *application) showReviewHandler(c *gin.Context) {
+	review, ok := app.readReviewParam(c)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"review": review}, nil)
+}
+
+// updateReviewHandler lets authors edit their own reviews. Like updateMovieHandler it
+// relies on the version number to reject edits made from a stale copy.
+func (app *application) updateReviewHandler(c *gin.Context) {
+	var input struct {
+		Rating *int16  `json:"rating"`
+		Body   *string `json:"body"`
+	}
+
+	review, ok := app.readReviewParam(c)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	if review.UserID == nil || *review.UserID != user.ID {
+		app.notPermittedResponse(c)
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	v := validator.New()
+
+	if models.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"review": review}, nil)
+}
+
+// deleteReviewHandler removes a review. Authors can delete their own reviews, and
+// users with the reviews:moderate permission can delete anyone's.
+func (app *application) deleteReviewHandler(c *gin.Context) {
+	review, ok := app.readReviewParam(c)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	if review.UserID == nil || *review.UserID != user.ID {
+		permissions, err := app.userPermissions(c)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return
+		}
+
+		if !permissions.Include("reviews:moderate") {
+			app.notPermittedResponse(c)
+			return
+		}
+	}
+
+	err := app.models.Reviews.Delete(review.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+}
+
+// readMovieParam loads the movie identified by the id route parameter. If the movie
+// can't be loaded it writes the error response and returns false.
+func (app *application) readMovieParam(c *gin.Context) (*models.Movie, bool) {
+	id, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return nil, false
+	}
+
+	return movie, true
+}
+
+// readReviewParam loads the review identified by the review_id route parameter,
+// which must belong to the movie identified by the id parameter. Reviews of a deleted
+// movie are not found, like the movie itself.
+func (app *application) readReviewParam(c *gin.Context) (*models.Review, bool) {
+	movie, ok := app.readMovieParam(c)
+	if !ok {
+		return nil, false
+	}
+
+	id, err := app.readInt64Param(c, "review_id")
+	if err != nil {
+		app.notFoundResponse(c)
+		return nil, false
+	}
+
+	review, err := app.models.Reviews.Get(movie.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return nil, false
+	}
+
+	return review, true
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+func (app *application) listRolesHandler(c *gin.Context) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"roles": roles}, nil)
+}
+
+func (app *application) createRoleHandler(c *gin.Context) {
+	var input struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	role := &models.Role{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	if models.ValidateRole(v, role, codes); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateRoleName):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/admin/roles/%s", role.Name))
+
+	app.writeJSON(c, http.StatusCreated, envelope{"role": role}, headers)
+}
+
+// updateRoleHandler replaces the permission set of a role. The change applies to
+// every user with the role from their next request (or, for JWTs, their next token).
+func (app *application) updateRoleHandler(c *gin.Context) {
+	var input struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	role, err := app.models.Roles.GetByName(c.Param("role"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	codes, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	role.Permissions = input.Permissions
+
+	v := validator.New()
+
+	models.ValidateRole(v, role, codes)
+
+	// Taking users:admin away from the admin role could leave nobody able to manage
+	// users, so it always keeps it.
+	if role.Name == "admin" {
+		v.Check(role.Permissions.Include("users:admin"), "permissions", "must include users:admin for the admin role")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Update(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"role": role}, nil)
+}
+
+func (app *application) assignUserRoleHandler(c *gin.Context) {
+	var input struct {
+		Role string `json:"role"`
+	}
+
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	_, err = app.models.Roles.GetByName(input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("role", "must be a known role")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, input.Role)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	access, err := app.userAccess(user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, access, nil)
+}
+
+func (app *application) unassignUserRoleHandler(c *gin.Context) {
+	user, ok := app.readUserParam(c)
+	if !ok {
+		return
+	}
+
+	name := c.Param("role")
+
+	if name == "admin" && !app.checkNotSelf(c, user) {
+		return
+	}
+
+	err := app.models.Roles.RemoveForUser(user.ID, name)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	access, err := app.userAccess(user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, access, nil)
+}
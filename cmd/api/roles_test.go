@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRegisterUserAssignsViewerRole(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	status, body := ts.do(t, http.MethodPost, "/v1/users", "", map[string]string{
+		"name":     "Alice",
+		"email":    "alice@example.com",
+		"password": "pa55word1234",
+	})
+	if status != http.StatusAccepted {
+		t.Fatalf("register: got status %d; want %d; body %v", status, http.StatusAccepted, body)
+	}
+
+	user, err := app.models.User.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(roles) != 1 || roles[0] != "viewer" {
+		t.Errorf("got roles %v; want [viewer]", roles)
+	}
+
+	granted, err := app.models.Permissions.GetGrantedForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(granted) != 0 {
+		t.Errorf("got direct grants %v; want none", granted)
+	}
+}
+
+func TestRequirePermissionUsesRoles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	user := insertTestUser(t, app, "alice@example.com", "pa55word1234")
+	token := ts.login(t, "alice@example.com", "pa55word1234")
+
+	status, _ := ts.do(t, http.MethodGet, "/v1/movies", token, nil)
+	if status != http.StatusOK {
+		t.Errorf("list movies as viewer: got status %d; want %d", status, http.StatusOK)
+	}
+
+	status, _ = ts.do(t, http.MethodPost, "/v1/movies", token, map[string]any{})
+	if status != http.StatusForbidden {
+		t.Errorf("create movie as viewer: got status %d; want %d", status, http.StatusForbidden)
+	}
+
+	err := app.models.Roles.AddForUser(user.ID, "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty movie fails validation, which shows the permission check passed.
+	status, _ = ts.do(t, http.MethodPost, "/v1/movies", token, map[string]any{})
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("create movie as editor: got status %d; want %d", status, http.StatusUnprocessableEntity)
+	}
+}
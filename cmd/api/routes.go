@@ -4,24 +4,149 @@ import "github.com/gin-gonic/gin"
 
 func (app *application) routes() *gin.Engine {
 	router := gin.Default()
+
+	// gin trusts forwarding headers from every address unless told otherwise, which
+	// would let clients pick their own IP. The list was validated by main, and an
+	// empty one makes ClientIP fall back to the connection's remote address.
+	_ = router.SetTrustedProxies(app.config.trustedProxies)
+
 	router.Use(app.inputValidation())
 	router.Use(app.recoverPanic())
 	router.Use(app.rateLimiter())
+	router.Use(app.authenticate())
+
+	router.GET("/.well-known/jwks.json", app.jwksHandler)
 
 	v1 := router.Group("/v1")
 	{
 		v1.GET("/health", app.Health)
 
-		v1.POST("/movies", app.createMovieHandler)
-		v1.GET("/movies", app.listMoviesHandler)
-		v1.PATCH("/movies/:id", app.updateMovieHandler)
-		v1.GET("/movies/:id", app.showMovieHandler)
-		v1.DELETE("/movies/:id", app.deleteMovieHandler)
+		moviesRead := v1.Group("/movies", app.requireActivatedUser(), app.requirePermission("movies:read"))
+		{
+			moviesRead.GET("", app.listMoviesHandler)
+			moviesRead.GET("/:id", app.showMovieHandler)
+
+			moviesRead.GET("/:id/reviews", app.listReviewsHandler)
+			moviesRead.POST("/:id/reviews", app.createReviewHandler)
+			moviesRead.GET("/:id/reviews/:review_id", app.showReviewHandler)
+			moviesRead.PATCH("/:id/reviews/:review_id", app.updateReviewHandler)
+			moviesRead.DELETE("/:id/reviews/:review_id", app.deleteReviewHandler)
+		}
+
+		moviesWrite := v1.Group("/movies", app.requireActivatedUser(), app.requirePermission("movies:write"))
+		{
+			moviesWrite.POST("", app.createMovieHandler)
+			moviesWrite.PATCH("/:id", app.updateMovieHandler)
+			moviesWrite.DELETE("/:id", app.deleteMovieHandler)
+			moviesWrite.POST("/:id/restore", app.restoreMovieHandler)
+
+			moviesWrite.POST("/:id/credits", app.createCreditHandler)
+			moviesWrite.DELETE("/:id/credits/:credit_id", app.deleteCreditHandler)
+		}
+
+		peopleRead := v1.Group("/people", app.requireActivatedUser(), app.requirePermission("movies:read"))
+		{
+			peopleRead.GET("", app.listPeopleHandler)
+			peopleRead.GET("/:id", app.showPersonHandler)
+		}
+
+		peopleWrite := v1.Group("/people", app.requireActivatedUser(), app.requirePermission("movies:write"))
+		{
+			peopleWrite.POST("", app.createPersonHandler)
+			peopleWrite.PATCH("/:id", app.updatePersonHandler)
+			peopleWrite.DELETE("/:id", app.deletePersonHandler)
+		}
+
+		collections := v1.Group("/collections", app.requireActivatedUser(), app.requirePermission("movies:read"))
+		{
+			collections.GET("", app.listCollectionsHandler)
+			collections.POST("", app.createCollectionHandler)
+			collections.GET("/:slug", app.showCollectionHandler)
+			collections.PATCH("/:slug", app.updateCollectionHandler)
+			collections.DELETE("/:slug", app.deleteCollectionHandler)
+			collections.PUT("/:slug/order", app.reorderCollectionHandler)
+			collections.PUT("/:slug/items/:id", app.putCollectionItemHandler)
+			collections.DELETE("/:slug/items/:id", app.deleteCollectionItemHandler)
+		}
 
 		v1.POST("/users", app.registerUserHandler)
 		v1.PUT("/users/activated", app.activateUserHandler)
+		v1.PUT("/users/password", app.updateUserPasswordHandler)
+		v1.PUT("/users/email", app.confirmEmailChangeHandler)
+		v1.POST("/users/export", app.downloadDataExportHandler)
+
+		me := v1.Group("/users/me", app.requireAuthenticatedUser())
+		{
+			me.GET("", app.showCurrentUserHandler)
+			me.PATCH("", app.requireActivatedUser(), app.requireUserCredentials(), app.updateCurrentUserHandler)
+			me.DELETE("", app.requireUserCredentials(), app.deleteCurrentUserHandler)
+			me.PUT("/password", app.requireUserCredentials(), app.changeCurrentUserPasswordHandler)
+			me.POST("/export", app.requireActivatedUser(), app.requireUserCredentials(), app.createDataExportHandler)
+			me.POST("/email", app.requireActivatedUser(), app.requireUserCredentials(), app.createEmailChangeHandler)
+
+			watchlist := me.Group("/watchlist", app.requireActivatedUser(), app.requirePermission("movies:read"))
+			{
+				watchlist.GET("", app.listWatchlistHandler)
+				watchlist.PUT("/:id", app.addToWatchlistHandler)
+				watchlist.DELETE("/:id", app.removeFromWatchlistHandler)
+			}
+
+			watched := me.Group("/watched", app.requireActivatedUser(), app.requirePermission("movies:read"))
+			{
+				watched.GET("", app.listWatchedHandler)
+				watched.PUT("/:id", app.markWatchedHandler)
+				watched.DELETE("/:id", app.unmarkWatchedHandler)
+			}
+
+			me.GET("/collections", app.requireActivatedUser(), app.requirePermission("movies:read"), app.listCurrentUserCollectionsHandler)
+
+			me.GET("/sessions", app.listSessionsHandler)
+			me.DELETE("/sessions/:id", app.requireUserCredentials(), app.deleteSessionHandler)
+
+			twoFactor := me.Group("/totp", app.requireActivatedUser(), app.requireUserCredentials())
+			{
+				twoFactor.POST("", app.createTOTPHandler)
+				twoFactor.POST("/confirm", app.confirmTOTPHandler)
+				twoFactor.DELETE("", app.deleteTOTPHandler)
+			}
+
+			apiKeys := me.Group("/api-keys", app.requireActivatedUser(), app.requireUserCredentials())
+			{
+				apiKeys.POST("", app.createApiKeyHandler)
+				apiKeys.GET("", app.listApiKeysHandler)
+				apiKeys.DELETE("/:id", app.deleteApiKeyHandler)
+			}
+		}
 
 		v1.POST("/tokens/authentication", app.createAuthenticationTokenHandler)
+		v1.POST("/tokens/authentication/mfa", app.createMFAAuthenticationTokenHandler)
+		v1.POST("/tokens/magic-link", app.createMagicLinkTokenHandler)
+		v1.POST("/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
+		v1.POST("/tokens/oidc/:provider/authorization", app.createOIDCAuthorizationHandler)
+		v1.POST("/tokens/oidc/:provider", app.createOIDCAuthenticationTokenHandler)
+		v1.POST("/tokens/refresh", app.refreshAuthenticationTokenHandler)
+		v1.DELETE("/tokens/authentication", app.requireAuthenticatedUser(), app.deleteAuthenticationTokenHandler)
+		v1.DELETE("/tokens/authentication/all", app.requireAuthenticatedUser(), app.requireUserCredentials(), app.deleteAllAuthenticationTokensHandler)
+		v1.POST("/tokens/activation", app.createActivationTokenHandler)
+		v1.POST("/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+		admin := v1.Group("/admin", app.requireActivatedUser(), app.requirePermission("users:admin"))
+		{
+			admin.GET("/users", app.listUsersHandler)
+			admin.GET("/users/:id", app.showUserHandler)
+			admin.PUT("/users/:id/activated", app.updateUserActivationHandler)
+			admin.PUT("/users/:id/suspension", app.suspendUserHandler)
+			admin.DELETE("/users/:id/suspension", app.unsuspendUserHandler)
+			admin.DELETE("/users/:id/tokens", app.deleteUserTokensHandler)
+			admin.POST("/users/:id/permissions", app.grantUserPermissionHandler)
+			admin.DELETE("/users/:id/permissions/:code", app.revokeUserPermissionHandler)
+			admin.POST("/users/:id/roles", app.assignUserRoleHandler)
+			admin.DELETE("/users/:id/roles/:role", app.unassignUserRoleHandler)
+
+			admin.GET("/roles", app.listRolesHandler)
+			admin.POST("/roles", app.createRoleHandler)
+			admin.PUT("/roles/:role", app.updateRoleHandler)
+		}
 	}
 
 	router.NoMethod(app.methodNotAllowedResponse)
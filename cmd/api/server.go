@@ -25,6 +25,23 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	// Background jobs run until the server starts shutting down, and the shutdown
+	// waits for a job that is in progress to finish.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	if app.config.movies.purgeRetention > 0 {
+		app.background(func() {
+			app.purgeDeletedMovies(jobsCtx)
+		})
+	}
+
+	if app.config.login.store == "postgres" {
+		app.background(func() {
+			app.purgeLoginAttempts(jobsCtx)
+		})
+	}
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 
@@ -36,6 +53,7 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
+		stopJobs()
 		app.wg.Wait()
 
 		shutdownError <- srv.Shutdown(ctx)
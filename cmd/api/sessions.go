@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+// listSessionsHandler lists the caller's sessions. Sessions are read from the refresh
+// tokens of each token family, which are stored in JWT mode too, so JWT sessions are
+// listed even though the JWTs themselves never are.
+func (app *application) listSessionsHandler(c *gin.Context) {
+	user := app.contextGetUser(c)
+
+	familyID, err := app.currentSessionFamily(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, familyID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"sessions": sessions}, nil)
+}
+
+// deleteSessionHandler revokes one of the caller's sessions. In JWT mode this stops
+// the session from being refreshed, but a JWT it already issued stays valid until it
+// expires, as no revocation list is kept.
+func (app *application) deleteSessionHandler(c *gin.Context) {
+	user := app.contextGetUser(c)
+	id := c.Param("id")
+
+	v := validator.New()
+
+	if models.ValidateSessionID(v, id); !v.Valid() {
+		app.notFoundResponse(c)
+		return
+	}
+
+	err := app.models.Tokens.DeleteSessionForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+}
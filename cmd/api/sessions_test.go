@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSessionsFollowTokenFamily(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	insertTestUser(t, app, "alice@example.com", "pa55word1234")
+
+	status, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{
+		"email":    "alice@example.com",
+		"password": "pa55word1234",
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("login: got status %d; body %v", status, body)
+	}
+
+	refreshToken := body["refresh_token"].(map[string]any)["token"].(string)
+	other := ts.login(t, "alice@example.com", "pa55word1234")
+
+	sessionID := func(token string) string {
+		t.Helper()
+
+		status, body := ts.do(t, http.MethodGet, "/v1/users/me/sessions", token, nil)
+		if status != http.StatusOK {
+			t.Fatalf("list sessions: got status %d; body %v", status, body)
+		}
+
+		sessions := body["sessions"].([]any)
+		if len(sessions) != 2 {
+			t.Fatalf("got %d sessions; want 2", len(sessions))
+		}
+
+		for _, s := range sessions {
+			session := s.(map[string]any)
+			if session["current"] == false {
+				return session["id"].(string)
+			}
+		}
+
+		t.Fatal("no session other than the current one")
+		return ""
+	}
+
+	before := sessionID(other)
+
+	status, body = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]string{"refresh_token": refreshToken})
+	if status != http.StatusCreated {
+		t.Fatalf("refresh: got status %d; body %v", status, body)
+	}
+
+	refreshToken = body["refresh_token"].(map[string]any)["token"].(string)
+
+	// Expire the refreshed session's authentication token, leaving only its refresh
+	// token to keep the session alive.
+	_, err := app.models.Tokens.DB.Exec(`UPDATE tokens SET expiry = NOW() - INTERVAL '1 minute' WHERE scope = 'authentication' AND family_id = $1`, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after := sessionID(other); after != before {
+		t.Errorf("session id changed from %s to %s across a refresh", before, after)
+	}
+
+	status, body = ts.do(t, http.MethodDelete, "/v1/users/me/sessions/"+before, other, nil)
+	if status != http.StatusOK {
+		t.Fatalf("revoke session: got status %d; body %v", status, body)
+	}
+
+	status, _ = ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]string{"refresh_token": refreshToken})
+	if status != http.StatusUnauthorized {
+		t.Errorf("refresh in a revoked session: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	status, body = ts.do(t, http.MethodGet, "/v1/users/me/sessions", other, nil)
+	if status != http.StatusOK {
+		t.Fatalf("list sessions after revoking: got status %d; body %v", status, body)
+	}
+
+	if sessions := body["sessions"].([]any); len(sessions) != 1 {
+		t.Errorf("got %d sessions after revoking one; want 1", len(sessions))
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/mailer"
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/testdb"
+	"github.com/fayazp088/greenlight/internal/throttle"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+
+	// The default cost makes every test user take a noticeable time to create.
+	models.SetPasswordHasher(models.BcryptHasher{Cost: 4})
+}
+
+// newTestApplication returns an application backed by a throwaway database. Tests
+// calling it are skipped when no test database is configured.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	var cfg config
+	cfg.env = "test"
+	cfg.auth.accessTokenTTL = 15 * time.Minute
+	cfg.auth.refreshTokenTTL = 24 * time.Hour
+
+	db := testdb.New(t)
+
+	return &application{
+		config: cfg,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: models.New(db),
+		mailer: mailer.New("localhost", 25, "", "", "Greenlight <no-reply@greenlight.net>"),
+
+		loginThrottle:     throttle.NewLimiter(throttle.NewMemoryStore(time.Hour), 5, time.Second, time.Hour),
+		magicLinkThrottle: throttle.NewLimiter(throttle.NewMemoryStore(magicLinkLockout), magicLinkMaxRequests, magicLinkBackoff, magicLinkLockout),
+	}
+}
+
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts}
+}
+
+// do sends a request with an optional JSON body and bearer token, and returns the
+// status code along with the decoded JSON response.
+func (ts *testServer) do(t *testing.T, method, path, token string, body any) (int, map[string]any) {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var decoded map[string]any
+
+	err = json.NewDecoder(res.Body).Decode(&decoded)
+	if err != nil && err != io.EOF {
+		t.Fatalf("%s %s: decoding response: %v", method, path, err)
+	}
+
+	return res.StatusCode, decoded
+}
+
+// login authenticates with an email address and password, and returns the plaintext
+// authentication token.
+func (ts *testServer) login(t *testing.T, email, password string) string {
+	t.Helper()
+
+	status, body := ts.do(t, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{
+		"email":    email,
+		"password": password,
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("login as %s: got status %d; body %v", email, status, body)
+	}
+
+	token, _ := body["token"].(map[string]any)
+	plaintext, _ := token["token"].(string)
+	if plaintext == "" {
+		t.Fatalf("login as %s: no token in response %v", email, body)
+	}
+
+	return plaintext
+}
+
+// insertTestUser creates an activated user with the given password and the default
+// role of a newly registered account.
+func insertTestUser(t *testing.T, app *application, email, password string) *models.User {
+	t.Helper()
+
+	user := &models.User{
+		Name:      "Test User",
+		Email:     email,
+		Activated: true,
+	}
+
+	err := user.Password.Set(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.User.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Roles.AddForUser(user.ID, "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
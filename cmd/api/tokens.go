@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/fayazp088/greenlight/internal/jwt"
 	"github.com/fayazp088/greenlight/internal/models"
 	"github.com/fayazp088/greenlight/internal/validator"
 	"github.com/gin-gonic/gin"
@@ -33,6 +37,12 @@ func (app *application) createAuthenticationTokenHandler(c *gin.Context) {
 		return
 	}
 
+	// Refuse the attempt up front if the email address or client IP is backing off
+	// or locked out, before spending any time on a bcrypt comparison.
+	if !app.checkLoginThrottle(c, input.Email) {
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was
 	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
 	// Unauthorized response to the client (we will create this helper in a moment).
@@ -40,6 +50,7 @@ func (app *application) createAuthenticationTokenHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
+			app.recordLoginFailure(c, input.Email, nil)
 			app.invalidCredentialsResponse(c)
 		default:
 			app.serverErrorResponse(c, err)
@@ -57,18 +68,633 @@ func (app *application) createAuthenticationTokenHandler(c *gin.Context) {
 	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
 	// helper again and return.
 	if !match {
+		app.recordLoginFailure(c, input.Email, user)
 		app.invalidCredentialsResponse(c)
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'
+	err = app.loginThrottle.Succeed(loginThrottleEmailKey(input.Email))
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	// Now that we know the plaintext password, upgrade the stored hash if it was made
+	// with an outdated algorithm or cost. A failure here shouldn't stop the login.
+	if user.Password.NeedsRehash() {
+		err = user.Password.Set(input.Password)
+		if err == nil {
+			err = app.models.User.Update(user)
+		}
+		if err != nil {
+			app.logError(c, err)
+		}
+	}
+
+	app.completeLogin(c, user)
+}
+
+// completeLogin finishes logging in a user whose first factor (their password, or a
+// magic link) has been verified, and writes the response. Users with two-factor
+// authentication enabled get a short-lived token instead, which must be exchanged
+// together with a valid code for the real tokens.
+func (app *application) completeLogin(c *gin.Context, user *models.User) {
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(c)
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if twoFactor.Enabled {
+		mfaToken, err := app.models.Tokens.New(user.ID, 5*time.Minute, models.ScopeMFAPending)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return
+		}
+
+		app.writeJSON(c, http.StatusAccepted, envelope{"mfa_required": true, "mfa_token": mfaToken}, nil)
+		return
+	}
+
+	// Otherwise we start a new session: a short-lived authentication token plus a
+	// refresh token in a new token family.
+	tokens, err := app.newAuthenticationTokens(c, user, "")
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusCreated, tokens, nil)
+}
+
+func loginThrottleEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func loginThrottleIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLoginThrottle reports whether a login attempt for the email address may go
+// ahead. Otherwise it writes a 429 response; the response is the same whether the
+// email address or the client IP is throttled.
+func (app *application) checkLoginThrottle(c *gin.Context, email string) bool {
+	var retryAfter time.Duration
+
+	for _, key := range []string{loginThrottleEmailKey(email), loginThrottleIPKey(c.ClientIP())} {
+		wait, err := app.loginThrottle.Check(key)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return false
+		}
+
+		retryAfter = max(retryAfter, wait)
+	}
+
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(c, retryAfter)
+		return false
+	}
+
+	return true
+}
+
+// purgeLoginAttempts deletes throttling history from the postgres store once it is
+// older than the longest lockout, and so no longer affects either the login or the
+// magic link limiter, checking every login lockout period until ctx is cancelled.
+// The memory stores expire entries themselves.
+func (app *application) purgeLoginAttempts(ctx context.Context) {
+	retention := max(app.config.login.lockout, magicLinkLockout)
+
+	ticker := time.NewTicker(app.config.login.lockout)
+	defer ticker.Stop()
+
+	for {
+		purged, err := app.models.LoginAttempts.DeleteExpired(time.Now().Add(-retention))
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if purged > 0 {
+			app.logger.Info("purged expired login attempts", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordLoginFailure counts a failed login against both the email address and the
+// client IP. If this failure locks out an existing account, its owner is emailed.
+// Errors are only logged, since the client gets an invalid credentials response
+// either way.
+func (app *application) recordLoginFailure(c *gin.Context, email string, user *models.User) {
+	_, err := app.loginThrottle.Fail(loginThrottleIPKey(c.ClientIP()))
+	if err != nil {
+		app.logError(c, err)
+	}
+
+	lockedOut, err := app.loginThrottle.Fail(loginThrottleEmailKey(email))
+	if err != nil {
+		app.logError(c, err)
+		return
+	}
+
+	if !lockedOut || user == nil {
+		return
+	}
+
+	ip := c.ClientIP()
+
+	app.logger.Warn("account locked out after repeated failed logins", "user_id", user.ID, "ip", ip)
+
+	app.background(func() {
+		data := map[string]any{
+			"lockoutMinutes": int(app.config.login.lockout.Minutes()),
+			"ip":             ip,
+		}
+
+		err := app.mailer.Send(user.Email, "account_lockout.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
+// newAuthenticationTokens issues an authentication token and a refresh token for the
+// user in the given token family, starting a new family if familyID is empty. Any
+// earlier authentication token in the family is replaced. In JWT mode the
+// authentication token is a signed JWT carrying the user's permissions instead of an
+// opaque token stored in the database.
+func (app *application) newAuthenticationTokens(c *gin.Context, user *models.User, familyID string) (envelope, error) {
+	var err error
+
+	if familyID == "" {
+		familyID, err = models.NewFamilyID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var token *models.Token
+
+	if app.jwt != nil {
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		claims := jwt.Claims{
+			Subject:     strconv.FormatInt(user.ID, 10),
+			SessionID:   familyID,
+			Activated:   user.Activated,
+			Permissions: permissions,
+		}
+
+		plaintext, expiry, err := app.jwt.Sign(claims, app.config.auth.accessTokenTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		token = &models.Token{Plaintext: plaintext, Expiry: expiry}
+	} else {
+		err = app.models.Tokens.DeleteFamilyScope(familyID, models.ScopeAuthentication)
+		if err != nil {
+			return nil, err
+		}
+
+		token, err = app.models.Tokens.NewInFamily(user.ID, app.config.auth.accessTokenTTL, models.ScopeAuthentication, familyID, c.Request.UserAgent(), c.ClientIP())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshToken, err := app.models.Tokens.NewInFamily(user.ID, app.config.auth.refreshTokenTTL, models.ScopeRefresh, familyID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"token": token, "refresh_token": refreshToken}, nil
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new authentication
+// token. Refresh tokens are single-use and rotated on every exchange; presenting one
+// that has already been used revokes its entire token family, since it means the token
+// has leaked.
+func (app *application) refreshAuthenticationTokenHandler(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	current, err := app.models.Tokens.GetUnexpired(models.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.MarkUsed(current.Hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTokenReused):
+			app.logger.Warn("refresh token reuse detected, revoking token family", "user_id", current.UserID, "family_id", current.FamilyID)
+
+			err = app.models.Tokens.DeleteFamily(current.FamilyID)
+			if err != nil {
+				app.serverErrorResponse(c, err)
+				return
+			}
+
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	user, err := app.models.User.Get(current.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(c)
+		return
+	}
+
+	// Issue the next authentication and refresh tokens in the rotation, replacing the
+	// family's previous authentication token.
+	tokens, err := app.newAuthenticationTokens(c, user, current.FamilyID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusCreated, tokens, nil)
+}
+
+func (app *application) createPasswordResetTokenHandler(c *gin.Context) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	// The response is identical whether or not a matching (activated) account
+	// exists, so that this endpoint can't be used to discover registered emails.
+	message := "an email will be sent to you containing password reset instructions"
+
+	user, err := app.models.User.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+		return
+	}
+
+	// Otherwise, create a new password reset token with a 45-minute expiry time.
+	token, err := app.models.Tokens.New(user.ID, 45*time.Minute, models.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+}
+
+func (app *application) createActivationTokenHandler(c *gin.Context) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user, err := app.models.User.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	// Remove any previously issued activation tokens so that only the newest one
+	// can be used.
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, models.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+		}
+
+		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	message := "an email will be sent to you containing activation instructions"
+	app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+}
+
+// deleteAuthenticationTokenHandler revokes the authentication token that was used to
+// make the request, effectively logging the caller out of the current session.
+func (app *application) deleteAuthenticationTokenHandler(c *gin.Context) {
+	// A JWT can't be revoked before it expires, so for those we end the session by
+	// deleting the refresh tokens of its family instead.
+	if claims, ok := app.contextGetClaims(c); ok {
+		err := app.models.Tokens.DeleteFamily(claims.SessionID)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return
+		}
+
+		app.writeJSON(c, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+		return
+	}
+
+	token := app.contextGetToken(c)
+
+	err := app.models.Tokens.DeleteByHash(models.HashToken(token))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+}
+
+// deleteAllAuthenticationTokensHandler revokes every authentication token belonging to
+// the caller, logging them out everywhere.
+func (app *application) deleteAllAuthenticationTokensHandler(c *gin.Context) {
+	user := app.contextGetUser(c)
+
+	for _, scope := range []string{models.ScopeAuthentication, models.ScopeRefresh} {
+		err := app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return
+		}
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "all authentication tokens successfully revoked"}, nil)
+}
+
+// jwksHandler publishes the public keys JWTs can be verified with. The document is
+// already a JSON object with a "keys" member, so it is written without an envelope.
+func (app *application) jwksHandler(c *gin.Context) {
+	if app.jwt == nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope(app.jwt.JWKS()), nil)
+}
+
+// Each magic link request doubles the wait before the next one for the same email
+// address, starting at magicLinkBackoff, and the last allowed request locks the
+// address out for magicLinkLockout.
+const (
+	magicLinkMaxRequests = 5
+	magicLinkBackoff     = time.Minute
+	magicLinkLockout     = time.Hour
+)
+
+// createMagicLinkTokenHandler emails a single-use login token to an activated user.
+// The response doesn't reveal whether such a user exists, and requests are rate
+// limited per email address whether or not it is registered.
+func (app *application) createMagicLinkTokenHandler(c *gin.Context) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	throttleKey := "magic-link:" + strings.ToLower(input.Email)
+
+	retryAfter, err := app.magicLinkThrottle.Check(throttleKey)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.rateLimitExceededRetryResponse(c, retryAfter)
+		return
+	}
+
+	_, err = app.magicLinkThrottle.Fail(throttleKey)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	message := "if an activated account exists for this email address, a login link will be sent to it"
+
+	user, err := app.models.User.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	if !user.Activated || user.IsSuspended() {
+		app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 15*time.Minute, models.ScopeMagicLink)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"magicLinkToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_magic_link.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+}
+
+// exchangeMagicLinkTokenHandler logs a user in with a token from a magic link email.
+// Marking the token used is atomic, so it can only ever be exchanged once.
+func (app *application) exchangeMagicLinkTokenHandler(c *gin.Context) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	token, err := app.models.Tokens.GetUnexpired(models.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.MarkUsed(token.Hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTokenReused):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeAuthentication)
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeMagicLink, token.UserID)
 	if err != nil {
 		app.serverErrorResponse(c, err)
 		return
 	}
 
-	app.writeJSON(c, http.StatusCreated, envelope{"token": token}, nil)
+	user, err := app.models.User.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	if !user.Activated {
+		app.inactiveAccountResponse(c)
+		return
+	}
+
+	app.completeLogin(c, user)
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestLogoutRequiresAuthentication(t *testing.T) {
+	// An anonymous request never reaches the database, so this runs without one.
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	ts := newTestServer(t, app.routes())
+
+	for _, path := range []string{"/v1/tokens/authentication", "/v1/tokens/authentication/all"} {
+		status, body := ts.do(t, http.MethodDelete, path, "", nil)
+		if status != http.StatusUnauthorized {
+			t.Errorf("DELETE %s: got status %d; want %d; body %v", path, status, http.StatusUnauthorized, body)
+		}
+	}
+}
+
+func TestDeleteAuthenticationToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	insertTestUser(t, app, "alice@example.com", "pa55word1234")
+
+	current := ts.login(t, "alice@example.com", "pa55word1234")
+	other := ts.login(t, "alice@example.com", "pa55word1234")
+
+	status, body := ts.do(t, http.MethodDelete, "/v1/tokens/authentication", current, nil)
+	if status != http.StatusOK {
+		t.Fatalf("logout: got status %d; want %d; body %v", status, http.StatusOK, body)
+	}
+
+	status, _ = ts.do(t, http.MethodGet, "/v1/users/me", current, nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("revoked token: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	status, _ = ts.do(t, http.MethodDelete, "/v1/tokens/authentication", current, nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("second logout with revoked token: got status %d; want %d", status, http.StatusUnauthorized)
+	}
+
+	status, _ = ts.do(t, http.MethodGet, "/v1/users/me", other, nil)
+	if status != http.StatusOK {
+		t.Errorf("token of another session: got status %d; want %d", status, http.StatusOK)
+	}
+}
+
+func TestDeleteAllAuthenticationTokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	insertTestUser(t, app, "alice@example.com", "pa55word1234")
+	insertTestUser(t, app, "bob@example.com", "pa55word1234")
+
+	first := ts.login(t, "alice@example.com", "pa55word1234")
+	second := ts.login(t, "alice@example.com", "pa55word1234")
+	bob := ts.login(t, "bob@example.com", "pa55word1234")
+
+	status, body := ts.do(t, http.MethodDelete, "/v1/tokens/authentication/all", first, nil)
+	if status != http.StatusOK {
+		t.Fatalf("logout everywhere: got status %d; want %d; body %v", status, http.StatusOK, body)
+	}
+
+	for name, token := range map[string]string{"first": first, "second": second} {
+		status, _ := ts.do(t, http.MethodGet, "/v1/users/me", token, nil)
+		if status != http.StatusUnauthorized {
+			t.Errorf("%s revoked token: got status %d; want %d", name, status, http.StatusUnauthorized)
+		}
+	}
+
+	status, _ = ts.do(t, http.MethodGet, "/v1/users/me", bob, nil)
+	if status != http.StatusOK {
+		t.Errorf("token of another user: got status %d; want %d", status, http.StatusOK)
+	}
+}
+
+func TestClientIPIgnoresUntrustedForwardingHeaders(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		want           string
+	}{
+		{name: "no trusted proxies", want: "127.0.0.1"},
+		{name: "trusted proxy", trustedProxies: []string{"127.0.0.1"}, want: "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			app.config.trustedProxies = tt.trustedProxies
+
+			router := app.routes()
+			router.GET("/client-ip", func(c *gin.Context) {
+				c.String(http.StatusOK, c.ClientIP())
+			})
+
+			ts := newTestServer(t, router)
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/client-ip", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := string(body); got != tt.want {
+				t.Errorf("got client IP %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountRoutesRefuseApiKeys(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := insertTestUser(t, app, "alice@example.com", "pa55word1234")
+	session := ts.login(t, "alice@example.com", "pa55word1234")
+
+	key := &models.ApiKey{UserID: alice.ID, Name: "read only", Permissions: models.Permissions{"movies:read"}}
+
+	err := app.models.ApiKeys.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodDelete, "/v1/tokens/authentication/all", ""},
+		{http.MethodDelete, "/v1/users/me/sessions/1", ""},
+		{http.MethodPatch, "/v1/users/me", `{"name": "Mallory"}`},
+	}
+
+	for _, route := range routes {
+		req, err := http.NewRequest(route.method, ts.URL+route.path, strings.NewReader(route.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "ApiKey "+key.Plaintext)
+
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s with an API key: got status %d; want %d", route.method, route.path, res.StatusCode, http.StatusForbidden)
+		}
+	}
+
+	status, body := ts.do(t, http.MethodGet, "/v1/users/me", session, nil)
+	if status != http.StatusOK {
+		t.Fatalf("session after API key requests: got status %d; want %d", status, http.StatusOK)
+	}
+
+	user, _ := body["user"].(map[string]any)
+	if user["name"] != "Test User" {
+		t.Errorf("got name %v; want the name to be unchanged", user["name"])
+	}
+}
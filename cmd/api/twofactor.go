@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/totp"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+// createTOTPHandler starts two-factor enrollment by generating a new secret. The
+// secret isn't enforced until it is confirmed with a code from the user's app.
+func (app *application) createTOTPHandler(c *gin.Context) {
+	if app.totp == nil {
+		app.twoFactorUnavailableResponse(c)
+		return
+	}
+
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	encryptedSecret, err := app.totp.Encrypt(secret)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.TwoFactor.SetPendingSecret(user.ID, encryptedSecret)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			v := validator.New()
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusCreated, envelope{"totp": envelope{
+		"secret": totp.EncodeSecret(secret),
+		"uri":    totp.URI(app.config.totp.issuer, user.Email, secret),
+	}}, nil)
+}
+
+// confirmTOTPHandler enables two-factor authentication once the user proves their app
+// generates valid codes, and returns their recovery codes. This is the only time the
+// recovery codes are shown.
+func (app *application) confirmTOTPHandler(c *gin.Context) {
+	if app.totp == nil {
+		app.twoFactorUnavailableResponse(c)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if twoFactor.Enabled || twoFactor.EncryptedSecret == nil {
+		v.AddError("code", "no two-factor enrollment is pending")
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	if !app.validateTOTPCode(c, user.ID, twoFactor, input.Code) {
+		return
+	}
+
+	recoveryCodes, err := app.models.TwoFactor.Enable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+}
+
+// deleteTOTPHandler turns two-factor authentication off. It requires both the
+// password and a current code, so a stolen session alone can't downgrade the account.
+func (app *application) deleteTOTPHandler(c *gin.Context) {
+	if app.totp == nil {
+		app.twoFactorUnavailableResponse(c)
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	models.ValidatePasswordPlaintext(v, input.Password)
+	models.ValidateTOTPCode(v, input.Code)
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(c)
+		return
+	}
+
+	twoFactor, err := app.models.TwoFactor.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !twoFactor.Enabled {
+		v.AddError("totp", "two-factor authentication is not enabled")
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	if !app.validateTOTPCode(c, user.ID, twoFactor, input.Code) {
+		return
+	}
+
+	err = app.models.TwoFactor.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+}
+
+// createMFAAuthenticationTokenHandler completes a login for a user with two-factor
+// authentication, exchanging the mfa_pending token from the password step and either
+// a TOTP code or a recovery code for the authentication tokens.
+func (app *application) createMFAAuthenticationTokenHandler(c *gin.Context) {
+	if app.totp == nil {
+		app.twoFactorUnavailableResponse(c)
+		return
+	}
+
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	models.ValidateTokenPlaintext(v, input.MFAToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	v.Check(input.Code == "" || input.RecoveryCode == "", "code", "must not be provided together with recovery_code")
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	// The pending token is single-use whatever the outcome: a wrong code means
+	// starting the login again from the password, which keeps guessing codes slow.
+	// Consuming it is atomic, so concurrent requests can't share one password step.
+	token, err := app.models.Tokens.Consume(models.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	user, err := app.models.User.Get(token.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	if user.IsSuspended() {
+		app.accountSuspendedResponse(c)
+		return
+	}
+
+	if input.RecoveryCode != "" {
+		err = app.models.TwoFactor.ConsumeRecoveryCode(user.ID, input.RecoveryCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrRecordNotFound):
+				app.invalidCredentialsResponse(c)
+			default:
+				app.serverErrorResponse(c, err)
+			}
+			return
+		}
+	} else {
+		twoFactor, err := app.models.TwoFactor.Get(user.ID)
+		if err != nil {
+			app.serverErrorResponse(c, err)
+			return
+		}
+
+		if !app.validateTOTPCode(c, user.ID, twoFactor, input.Code) {
+			return
+		}
+	}
+
+	tokens, err := app.newAuthenticationTokens(c, user, "")
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusCreated, tokens, nil)
+}
+
+// validateTOTPCode checks a code against the user's secret and consumes its time step
+// so it can't be replayed. If the code is not accepted it writes the error response
+// and returns false.
+func (app *application) validateTOTPCode(c *gin.Context, userID int64, twoFactor *models.TwoFactor, code string) bool {
+	secret, err := app.totp.Decrypt(twoFactor.EncryptedSecret)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return false
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		app.invalidCredentialsResponse(c)
+		return false
+	}
+
+	err = app.models.TwoFactor.ConsumeStep(userID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTokenReused):
+			app.invalidCredentialsResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return false
+	}
+
+	return true
+}
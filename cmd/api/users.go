@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/fayazp088/greenlight/internal/models"
@@ -38,7 +39,15 @@ func (app *application) registerUserHandler(c *gin.Context) {
 
 	v := validator.New()
 
-	if models.ValidateUser(v, user); !v.Valid() {
+	models.ValidateUser(v, user)
+
+	err = models.ValidateNewPassword(v, "password", input.Password, user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(c, v.Errors)
 		return
 	}
@@ -57,6 +66,15 @@ func (app *application) registerUserHandler(c *gin.Context) {
 		return
 	}
 
+	// Assign the new user the "viewer" role by default, which lets them read movies.
+	// Users who registered before roles existed keep their direct movies:read grant,
+	// as it can't be told apart from one an admin gave deliberately.
+	err = app.models.Roles.AddForUser(user.ID, "viewer")
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
 	// After the user record has been created in the database, generate a new activation
 	// token for the user.
 	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, models.ScopeActivation)
@@ -133,3 +151,439 @@ func (app *application) activateUserHandler(c *gin.Context) {
 
 	app.writeJSON(c, http.StatusOK, envelope{"user": user}, nil)
 }
+
+func (app *application) updateUserPasswordHandler(c *gin.Context) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	// The new password is checked against the policy once the user is known, since
+	// the policy rejects passwords made from their email address or name.
+	models.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user, err := app.models.User.GetForToken(models.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = models.ValidateNewPassword(v, "password", input.Password, user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	// If everything was successful, then delete all password reset tokens for the user.
+	err = app.models.Tokens.DeleteAllForUser(models.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+}
+
+func (app *application) updateCurrentUserHandler(c *gin.Context) {
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if models.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"user": user}, nil)
+}
+
+// createEmailChangeHandler starts changing the email address of the authenticated
+// user. The new address is only stored as pending: a confirmation token is sent to
+// it, and the old address is told about the request, so that a hijacked session
+// can't silently move the account to an attacker's mailbox.
+func (app *application) createEmailChangeHandler(c *gin.Context) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	models.ValidateEmail(v, input.Email)
+	models.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(c)
+		return
+	}
+
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different from your current email address")
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	_, err = app.models.User.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(c, v.Errors)
+		return
+	case !errors.Is(err, models.ErrRecordNotFound):
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.User.SetPendingEmail(user.ID, input.Email)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	// Only the most recent email change request can be confirmed.
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"emailChangeToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(input.Email, "email_change_confirm.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		data = map[string]any{
+			"newEmail": input.Email,
+		}
+
+		err = app.mailer.Send(user.Email, "email_change_notice.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	message := "an email will be sent to the new address containing confirmation instructions"
+	app.writeJSON(c, http.StatusAccepted, envelope{"message": message}, nil)
+}
+
+func (app *application) confirmEmailChangeHandler(c *gin.Context) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if models.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user, err := app.models.User.GetForToken(models.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	pendingEmail, err := app.models.User.GetPendingEmail(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(c, v.Errors)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	user.Email = pendingEmail
+
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(c, v.Errors)
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	err = app.models.User.SetPendingEmail(user.ID, "")
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(models.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"user": user}, nil)
+}
+
+func (app *application) showCurrentUserHandler(c *gin.Context) {
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"user": user}, nil)
+}
+
+// changeCurrentUserPasswordHandler sets a new password for the authenticated user.
+// The current password is required, and every other session is logged out.
+func (app *application) changeCurrentUserPasswordHandler(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = models.ValidateNewPassword(v, "new_password", input.NewPassword, user)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(c)
+		return
+	}
+
+	err = user.Password.Set(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.User.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	familyID, err := app.currentSessionFamily(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteSessionsForUserExcept(user.ID, familyID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(models.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "your password was successfully changed"}, nil)
+}
+
+// deleteCurrentUserHandler closes the authenticated user's account after checking
+// their password again.
+func (app *application) deleteCurrentUserHandler(c *gin.Context) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.Password != "", "password", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	user, err := app.loadCurrentUser(c)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(c)
+		return
+	}
+
+	err = app.models.User.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "your account was successfully deleted"}, nil)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/models"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/gin-gonic/gin"
+)
+
+func (app *application) listWatchlistHandler(c *gin.Context) {
+	filters, ok := app.readListFilters(c, "-added_at", "added_at", "title", "year")
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	entries, metadata, err := app.models.Watchlist.GetAllForUser(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"watchlist": entries, "meta_data": metadata}, nil)
+}
+
+func (app *application) addToWatchlistHandler(c *gin.Context) {
+	movie, ok := app.readMovieParam(c)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	err := app.models.Watchlist.Add(user.ID, movie.ID)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "movie added to your watchlist"}, nil)
+}
+
+func (app *application) removeFromWatchlistHandler(c *gin.Context) {
+	movieID, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	err = app.models.Watchlist.Remove(user.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "movie removed from your watchlist"}, nil)
+}
+
+func (app *application) listWatchedHandler(c *gin.Context) {
+	filters, ok := app.readListFilters(c, "-watched_on", "watched_on", "rating", "title", "year")
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	entries, metadata, err := app.models.Watched.GetAllForUser(user.ID, filters)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"watched": entries, "meta_data": metadata}, nil)
+}
+
+// markWatchedHandler records that the user has watched a movie, on the given date
+// (today by default) and with an optional personal rating. Marking a movie again
+// replaces the earlier date and rating.
+func (app *application) markWatchedHandler(c *gin.Context) {
+	var input struct {
+		WatchedOn *string `json:"watched_on"`
+		Rating    *int16  `json:"rating"`
+	}
+
+	movie, ok := app.readMovieParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.readJSON(c, &input)
+	if err != nil {
+		app.badRequestResponse(c, err)
+		return
+	}
+
+	entry := &models.WatchedEntry{
+		UserID:    app.contextGetUser(c).ID,
+		Movie:     movie,
+		WatchedOn: time.Now().UTC().Truncate(24 * time.Hour),
+		Rating:    input.Rating,
+	}
+
+	v := validator.New()
+
+	if input.WatchedOn != nil {
+		entry.WatchedOn, err = time.Parse(time.DateOnly, *input.WatchedOn)
+		if err != nil {
+			v.AddError("watched_on", "must be a date in YYYY-MM-DD format")
+			app.failedValidationResponse(c, v.Errors)
+			return
+		}
+	}
+
+	if models.ValidateWatchedEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(c, v.Errors)
+		return
+	}
+
+	err = app.models.Watched.Mark(entry)
+	if err != nil {
+		app.serverErrorResponse(c, err)
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"watched": entry}, nil)
+}
+
+func (app *application) unmarkWatchedHandler(c *gin.Context) {
+	movieID, err := app.readIDParam(c)
+	if err != nil {
+		app.notFoundResponse(c)
+		return
+	}
+
+	user := app.contextGetUser(c)
+
+	err = app.models.Watched.Remove(user.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(c)
+		default:
+			app.serverErrorResponse(c, err)
+		}
+		return
+	}
+
+	app.writeJSON(c, http.StatusOK, envelope{"message": "movie removed from your watched history"}, nil)
+}
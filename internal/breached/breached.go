@@ -0,0 +1,70 @@
+package breached
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RangeDir checks passwords against a local copy of a breached password corpus in
+// the Have I Been Pwned range format. The directory holds one file per 5-character
+// SHA-1 prefix, named after the prefix (for example "21BD1" or "21BD1.txt"), with
+// one "SUFFIX:COUNT" line for each breached hash starting with that prefix.
+type RangeDir struct {
+	dir string
+}
+
+func NewRangeDir(dir string) (*RangeDir, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return nil, errors.New("breached: " + dir + " is not a directory")
+	}
+
+	return &RangeDir{dir: dir}, nil
+}
+
+// Contains reports whether the password appears in the corpus. A prefix with no
+// range file has no breached hashes.
+func (r *RangeDir) Contains(plaintext string) (bool, error) {
+	sum := sha1.Sum([]byte(plaintext))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		found, err := scanRange(filepath.Join(r.dir, name), suffix)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return found, err
+	}
+
+	return false, nil
+}
+
+func scanRange(path, suffix string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,49 @@
+package breached
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// The testdata directory holds a range file for "password", named with the .txt
+// extension and in the CRLF line endings the HIBP API serves, and one for "123456",
+// named without the extension and with a lowercase suffix.
+func TestContains(t *testing.T) {
+	r, err := NewRangeDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"123456", true},
+		{"Password", false},
+		{"correct horse battery staple", false},
+	}
+
+	for _, tt := range tests {
+		got, err := r.Contains(tt.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Contains(%q): got %t; want %t", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestNewRangeDirRequiresDirectory(t *testing.T) {
+	_, err := NewRangeDir(filepath.Join("testdata", "5BAA6.txt"))
+	if err == nil {
+		t.Error("a file: got no error")
+	}
+
+	_, err = NewRangeDir(filepath.Join("testdata", "missing"))
+	if err == nil {
+		t.Error("a missing directory: got no error")
+	}
+}
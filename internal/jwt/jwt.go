@@ -0,0 +1,300 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// Claims is the payload of the access tokens we issue. Alongside the registered
+// claims it carries enough about the user (activation state and permission codes) for
+// a service to authorize a request without a database round-trip.
+type Claims struct {
+	Issuer      string   `json:"iss"`
+	Subject     string   `json:"sub"`
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+	SessionID   string   `json:"sid,omitempty"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Key is a single signing key, identified by the kid header of the tokens it signs.
+type Key struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// KeySet holds every key that tokens may be verified with. The first key is the
+// active one and is used for signing; the rest are kept so that tokens signed before
+// a key rotation remain valid until they expire.
+type KeySet struct {
+	Issuer string
+	keys   []Key
+}
+
+// NewHMACKeySet builds an HS256 key set from a spec of the form
+// "kid1:secret1,kid2:secret2".
+func NewHMACKeySet(issuer, spec string) (*KeySet, error) {
+	ks := &KeySet{Issuer: issuer}
+
+	for _, entry := range splitSpec(spec) {
+		kid, secret, found := strings.Cut(entry, ":")
+		if !found || kid == "" || secret == "" {
+			return nil, fmt.Errorf("jwt: invalid HS256 key %q, expected kid:secret", entry)
+		}
+
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("jwt: HS256 secret for key %q must be at least 32 bytes long", kid)
+		}
+
+		ks.keys = append(ks.keys, Key{ID: kid, Algorithm: AlgHS256, secret: []byte(secret)})
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, errors.New("jwt: no HS256 keys configured")
+	}
+
+	return ks, nil
+}
+
+// NewEd25519KeySet builds an EdDSA key set from a spec of the form
+// "kid1=/path/to/key1.pem,kid2=/path/to/key2.pem", where each file holds a
+// PKCS #8 PEM-encoded Ed25519 private key.
+func NewEd25519KeySet(issuer, spec string) (*KeySet, error) {
+	ks := &KeySet{Issuer: issuer}
+
+	for _, entry := range splitSpec(spec) {
+		kid, path, found := strings.Cut(entry, "=")
+		if !found || kid == "" || path == "" {
+			return nil, fmt.Errorf("jwt: invalid Ed25519 key %q, expected kid=path", entry)
+		}
+
+		private, err := readEd25519PrivateKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: key %q: %w", kid, err)
+		}
+
+		ks.keys = append(ks.keys, Key{
+			ID:        kid,
+			Algorithm: AlgEdDSA,
+			private:   private,
+			public:    private.Public().(ed25519.PublicKey),
+		})
+	}
+
+	if len(ks.keys) == 0 {
+		return nil, errors.New("jwt: no Ed25519 keys configured")
+	}
+
+	return ks, nil
+}
+
+// Sign fills in the issuer and timestamps of the claims and returns them as a compact
+// JWS signed with the active key.
+func (ks *KeySet) Sign(claims Claims, ttl time.Duration) (string, time.Time, error) {
+	key := ks.keys[0]
+
+	now := time.Now()
+	expiry := now.Add(ttl)
+
+	claims.Issuer = ks.Issuer
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = expiry.Unix()
+
+	headerJSON, err := json.Marshal(header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+
+	return signingInput + "." + encode(key.sign([]byte(signingInput))), expiry, nil
+}
+
+// Verify checks the signature, issuer and expiry of a compact JWS and returns its
+// claims.
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := decode(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := json.Unmarshal(headerJSON, &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := ks.lookup(h.KeyID)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// Only accept the algorithm the key was configured for, so a token can't choose
+	// how it gets verified.
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := decode(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != ks.Issuer {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// JWKS returns the public keys of the set in JSON Web Key Set form. HS256 secrets are
+// symmetric and are never published, so an HMAC key set has no entries.
+func (ks *KeySet) JWKS() map[string]any {
+	keys := []map[string]string{}
+
+	for _, key := range ks.keys {
+		if key.Algorithm != AlgEdDSA {
+			continue
+		}
+
+		keys = append(keys, map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"use": "sig",
+			"alg": AlgEdDSA,
+			"kid": key.ID,
+			"x":   encode(key.public),
+		})
+	}
+
+	return map[string]any{"keys": keys}
+}
+
+func (ks *KeySet) lookup(kid string) (Key, bool) {
+	for _, key := range ks.keys {
+		if key.ID == kid {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+func (k Key) sign(input []byte) []byte {
+	switch k.Algorithm {
+	case AlgEdDSA:
+		return ed25519.Sign(k.private, input)
+	default:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func (k Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case AlgEdDSA:
+		return ed25519.Verify(k.public, input, signature)
+	default:
+		return hmac.Equal(k.sign(input), signature)
+	}
+}
+
+func readEd25519PrivateKey(path string) (ed25519.PrivateKey, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key")
+	}
+
+	return private, nil
+}
+
+func splitSpec(spec string) []string {
+	var entries []string
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,293 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer = "greenlight"
+	testSecret = "0123456789abcdef0123456789abcdef"
+)
+
+func newHMACKeySet(t *testing.T, spec string) *KeySet {
+	t.Helper()
+
+	ks, err := NewHMACKeySet(testIssuer, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ks
+}
+
+// newEd25519KeySet writes a fresh Ed25519 key for each kid to a PEM file and loads
+// them as a key set.
+func newEd25519KeySet(t *testing.T, kids ...string) *KeySet {
+	t.Helper()
+
+	var spec []string
+
+	for _, kid := range kids {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), kid+".pem")
+
+		err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		spec = append(spec, kid+"="+path)
+	}
+
+	ks, err := NewEd25519KeySet(testIssuer, strings.Join(spec, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ks
+}
+
+// forge builds a token with an arbitrary header and claims, signed by the given
+// function, to test how Verify treats tokens we would never issue.
+func forge(t *testing.T, h header, claims Claims, sign func(input []byte) []byte) string {
+	t.Helper()
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := encode(headerJSON) + "." + encode(claimsJSON)
+
+	return input + "." + encode(sign([]byte(input)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(input []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func validClaims() Claims {
+	now := time.Now()
+
+	return Claims{
+		Issuer:      testIssuer,
+		Subject:     "1",
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   now.Add(time.Minute).Unix(),
+		Permissions: []string{"movies:read"},
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for name, ks := range map[string]*KeySet{
+		AlgHS256: newHMACKeySet(t, "k1:"+testSecret),
+		AlgEdDSA: newEd25519KeySet(t, "k1"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			token, expiry, err := ks.Sign(Claims{Subject: "42", SessionID: "sid", Activated: true, Permissions: []string{"movies:read"}}, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := ks.Verify(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Subject != "42" || claims.SessionID != "sid" || !claims.Activated || claims.Issuer != testIssuer {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+
+			if claims.ExpiresAt != expiry.Unix() {
+				t.Errorf("got exp %d; want %d", claims.ExpiresAt, expiry.Unix())
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	hmacKeys := newHMACKeySet(t, "k1:"+testSecret)
+	edKeys := newEd25519KeySet(t, "ed1")
+
+	valid, _, err := hmacKeys.Sign(validClaims(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+
+	expired, _, err := hmacKeys.Sign(validClaims(), -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "someone-else"
+
+	tampered := validClaims()
+	tampered.Permissions = []string{"movies:read", "users:admin"}
+
+	tamperedClaims, err := json.Marshal(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature[0] ^= 1
+
+	tests := []struct {
+		name  string
+		ks    *KeySet
+		token string
+		want  error
+	}{
+		{
+			name:  "expired",
+			ks:    hmacKeys,
+			token: expired,
+			want:  ErrExpiredToken,
+		},
+		{
+			name:  "unknown kid",
+			ks:    hmacKeys,
+			token: forge(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "k2"}, validClaims(), hs256([]byte(testSecret))),
+			want:  ErrUnknownKey,
+		},
+		{
+			name:  "wrong alg for the key",
+			ks:    hmacKeys,
+			token: forge(t, header{Algorithm: AlgEdDSA, Type: "JWT", KeyID: "k1"}, validClaims(), hs256([]byte(testSecret))),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "alg none",
+			ks:    hmacKeys,
+			token: forge(t, header{Algorithm: "none", Type: "JWT", KeyID: "k1"}, validClaims(), func([]byte) []byte { return nil }),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "signed with another secret",
+			ks:    hmacKeys,
+			token: forge(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "k1"}, validClaims(), hs256([]byte(strings.Repeat("x", 32)))),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "tampered signature",
+			ks:    hmacKeys,
+			token: parts[0] + "." + parts[1] + "." + encode(signature),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "tampered claims",
+			ks:    hmacKeys,
+			token: parts[0] + "." + encode(tamperedClaims) + "." + parts[2],
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "wrong issuer",
+			ks:    hmacKeys,
+			token: forge(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "k1"}, wrongIssuer, hs256([]byte(testSecret))),
+			want:  ErrInvalidToken,
+		},
+		{
+			name:  "not three segments",
+			ks:    hmacKeys,
+			token: parts[0] + "." + parts[1],
+			want:  ErrInvalidToken,
+		},
+		{
+			// The classic algorithm confusion attack: an HS256 token keyed with the
+			// published Ed25519 public key must not verify against that key.
+			name:  "HS256 token against an EdDSA key",
+			ks:    edKeys,
+			token: forge(t, header{Algorithm: AlgHS256, Type: "JWT", KeyID: "ed1"}, validClaims(), hs256(edKeys.keys[0].public)),
+			want:  ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.ks.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got claims %+v and error %v; want error %v", claims, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAfterKeyRotation(t *testing.T) {
+	before := newHMACKeySet(t, "old:"+testSecret)
+
+	token, _, err := before.Sign(validClaims(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key signs from now on, and the old one is kept for verification only.
+	after := newHMACKeySet(t, "new:"+strings.Repeat("n", 32)+",old:"+testSecret)
+
+	_, err = after.Verify(token)
+	if err != nil {
+		t.Errorf("token signed with the previous key: got error %v", err)
+	}
+
+	rotated, _, err := after.Sign(validClaims(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = before.Verify(rotated)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token signed with the new key against the old set: got error %v; want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	if keys := newHMACKeySet(t, "k1:"+testSecret).JWKS()["keys"].([]map[string]string); len(keys) != 0 {
+		t.Errorf("HS256 key set published %v", keys)
+	}
+
+	edKeys := newEd25519KeySet(t, "ed1")
+
+	keys := edKeys.JWKS()["keys"].([]map[string]string)
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys; want 1", len(keys))
+	}
+
+	if keys[0]["kid"] != "ed1" || keys[0]["x"] != encode(edKeys.keys[0].public) {
+		t.Errorf("unexpected key %v", keys[0])
+	}
+}
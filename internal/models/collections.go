@@ -0,0 +1,481 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/data"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// Collection is a user-curated, ordered list of movies. Collections are addressed by
+// a random slug, so an unlisted collection can only be found by someone it has been
+// shared with. UserID is nil once the owner has deleted their account.
+type Collection struct {
+	ID          int64             `json:"-"`
+	UserID      *int64            `json:"user_id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	Slug        string            `json:"slug"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Visibility  string            `json:"visibility"`
+	ItemCount   int64             `json:"item_count"`
+	Version     int32             `json:"version"`
+	Items       []*CollectionItem `json:"items,omitempty"`
+}
+
+// CollectionItem is a movie in a collection, with the curator's note about it.
+type CollectionItem struct {
+	Movie    *Movie    `json:"movie"`
+	Position int32     `json:"position"`
+	Note     string    `json:"note"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+// CanView reports whether a user with the given permissions may see the collection.
+// Private collections are only visible to those who can edit them; public and
+// unlisted ones to anyone who has the slug.
+func (c *Collection) CanView(userID int64, permissions Permissions) bool {
+	return c.Visibility != VisibilityPrivate || c.CanEdit(userID, permissions)
+}
+
+// CanEdit reports whether a user with the given permissions may change or delete the
+// collection: its owner, or a moderator with the collections:moderate permission.
+// Moderators are the only ones who can edit a collection whose owner has deleted
+// their account.
+func (c *Collection) CanEdit(userID int64, permissions Permissions) bool {
+	return c.IsOwnedBy(userID) || permissions.Include("collections:moderate")
+}
+
+func (c *Collection) IsOwnedBy(userID int64) bool {
+	return c.UserID != nil && *c.UserID == userID
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Title != "", "title", "must be provided")
+	v.Check(len(collection.Title) <= 200, "title", "must not be more than 200 bytes long")
+
+	v.Check(len(collection.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+
+	v.Check(validator.PermittedValue(collection.Visibility, VisibilityPublic, VisibilityUnlisted, VisibilityPrivate), "visibility", "must be public, unlisted or private")
+}
+
+func ValidateCollectionNote(v *validator.Validator, note string) {
+	v.Check(len(note) <= 2_000, "note", "must not be more than 2000 bytes long")
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert() creates a collection with a new random slug.
+func (m CollectionModel) Insert(collection *Collection) error {
+	slug, err := generateSlug()
+	if err != nil {
+		return err
+	}
+
+	collection.Slug = slug
+
+	query := `
+		INSERT INTO collections (user_id, slug, title, description, visibility)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at, version`
+
+	args := []any{collection.UserID, collection.Slug, collection.Title, collection.Description, collection.Visibility}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.UpdatedAt, &collection.Version)
+}
+
+const collectionColumns = `collections.id, collections.user_id, collections.created_at, collections.updated_at,
+		collections.slug, collections.title, collections.description, collections.visibility, collections.version,
+		(SELECT count(*) FROM collection_items
+			INNER JOIN movies ON movies.id = collection_items.movie_id
+			WHERE collection_items.collection_id = collections.id AND movies.deleted_at IS NULL)`
+
+func scanCollection(row interface{ Scan(...any) error }, collection *Collection, dest ...any) error {
+	return row.Scan(append(dest,
+		&collection.ID,
+		&collection.UserID,
+		&collection.CreatedAt,
+		&collection.UpdatedAt,
+		&collection.Slug,
+		&collection.Title,
+		&collection.Description,
+		&collection.Visibility,
+		&collection.Version,
+		&collection.ItemCount,
+	)...)
+}
+
+func (m CollectionModel) GetBySlug(slug string) (*Collection, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM collections
+		WHERE slug = $1`, collectionColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var collection Collection
+
+	err := scanCollection(m.DB.QueryRowContext(ctx, query, slug), &collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET title = $1, description = $2, visibility = $3, updated_at = NOW(), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING updated_at, version`
+
+	args := []any{collection.Title, collection.Description, collection.Visibility, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.UpdatedAt, &collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	query := `
+		DELETE FROM collections
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ListPublic() searches the public collections by title.
+func (m CollectionModel) ListPublic(title string, filters data.Filters) ([]*Collection, data.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM collections
+		WHERE visibility = 'public'
+		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, collectionColumns, filters.SortColumn(), filters.SortDirection())
+
+	return m.list(query, filters, title)
+}
+
+// GetAllForUser() returns every collection a user owns, whatever its visibility.
+func (m CollectionModel) GetAllForUser(userID int64, filters data.Filters) ([]*Collection, data.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM collections
+		WHERE user_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, collectionColumns, filters.SortColumn(), filters.SortDirection())
+
+	return m.list(query, filters, userID)
+}
+
+func (m CollectionModel) list(query string, filters data.Filters, arg any) ([]*Collection, data.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, arg, filters.Limit(), filters.Offset())
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err = scanCollection(rows, &collection, &totalRecords)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// GetItems() returns the movies in a collection in their curated order.
+func (m CollectionModel) GetItems(collectionID int64) ([]*CollectionItem, error) {
+	query := fmt.Sprintf(`
+		SELECT movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+			ratings.rating, ratings.rating_count, collection_items.position, collection_items.note, collection_items.added_at
+		FROM collection_items
+		INNER JOIN movies ON movies.id = collection_items.movie_id
+		CROSS JOIN LATERAL (%s) AS ratings
+		WHERE collection_items.collection_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY collection_items.position, movies.id`, movieRatingsQuery)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*CollectionItem{}
+
+	for rows.Next() {
+		item := CollectionItem{Movie: &Movie{}}
+
+		err = rows.Scan(
+			&item.Movie.ID,
+			&item.Movie.CreatedAt,
+			&item.Movie.Title,
+			&item.Movie.Year,
+			&item.Movie.Runtime,
+			pq.Array(&item.Movie.Genres),
+			&item.Movie.Version,
+			&item.Movie.AverageRating,
+			&item.Movie.RatingCount,
+			&item.Position,
+			&item.Note,
+			&item.AddedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// PutItem() adds a movie to the end of a collection, or updates its note if it is
+// already in it.
+func (m CollectionModel) PutItem(collectionID, movieID int64, note string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the collection row serialises concurrent additions, so two movies
+	// can't be given the same position.
+	_, err = tx.ExecContext(ctx, `SELECT id FROM collections WHERE id = $1 FOR UPDATE`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO collection_items (collection_id, movie_id, position, note)
+		VALUES ($1, $2, (SELECT COALESCE(max(position), 0) + 1 FROM collection_items WHERE collection_id = $1), $3)
+		ON CONFLICT (collection_id, movie_id) DO UPDATE
+		SET note = EXCLUDED.note`
+
+	_, err = tx.ExecContext(ctx, query, collectionID, movieID, note)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE collections SET updated_at = NOW() WHERE id = $1`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CollectionModel) RemoveItem(collectionID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM collection_items WHERE collection_id = $1 AND movie_id = $2`, collectionID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE collections SET updated_at = NOW() WHERE id = $1`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reorder() puts the movies of a collection in the given order. movieIDs must list
+// every movie in the collection exactly once, otherwise ErrEditConflict is returned
+// as the caller's view of the collection is out of date. Deleted movies are hidden
+// from the collection, so they aren't expected in movieIDs; they keep their relative
+// order after the listed movies, and come back at the end if they are restored.
+func (m CollectionModel) Reorder(collectionID int64, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the collection row keeps movies from being added or removed while the
+	// new order is checked and applied.
+	_, err = tx.ExecContext(ctx, `SELECT id FROM collections WHERE id = $1 FOR UPDATE`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT collection_items.movie_id
+		FROM collection_items
+		INNER JOIN movies ON movies.id = collection_items.movie_id
+		WHERE collection_items.collection_id = $1 AND movies.deleted_at IS NULL`
+
+	rows, err := tx.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var current []int64
+
+	for rows.Next() {
+		var movieID int64
+
+		err = rows.Scan(&movieID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		current = append(current, movieID)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	sorted := slices.Clone(movieIDs)
+	slices.Sort(sorted)
+	slices.Sort(current)
+
+	if !slices.Equal(sorted, current) {
+		return ErrEditConflict
+	}
+
+	query = `
+		UPDATE collection_items
+		SET position = ordered.position
+		FROM (
+			SELECT listed.movie_id, listed.position
+			FROM unnest($2::bigint[]) WITH ORDINALITY AS listed (movie_id, position)
+			UNION ALL
+			SELECT hidden.movie_id, cardinality($2::bigint[]) + row_number() OVER (ORDER BY hidden.position, hidden.movie_id)
+			FROM collection_items AS hidden
+			WHERE hidden.collection_id = $1 AND hidden.movie_id <> ALL($2::bigint[])
+		) AS ordered
+		WHERE collection_items.collection_id = $1 AND collection_items.movie_id = ordered.movie_id`
+
+	_, err = tx.ExecContext(ctx, query, collectionID, pq.Array(movieIDs))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE collections SET updated_at = NOW() WHERE id = $1`, collectionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// generateSlug returns a random 24 character slug. With 120 random bits it can't be
+// guessed, which is what keeps unlisted collections unlisted.
+func generateSlug() (string, error) {
+	randomBytes := make([]byte, 15)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
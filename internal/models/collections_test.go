@@ -0,0 +1,133 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/fayazp088/greenlight/internal/testdb"
+)
+
+func TestReorderSkipsDeletedMovies(t *testing.T) {
+	m := New(testdb.New(t))
+
+	collection := &Collection{Title: "Favourites", Visibility: VisibilityPublic}
+
+	err := m.Collections.Insert(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+
+	for _, title := range []string{"A", "B", "C"} {
+		movie := &Movie{Title: title, Year: 2000, Runtime: 100, Genres: []string{"drama"}}
+
+		err := m.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.Collections.PutItem(collection.ID, movie.ID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, movie.ID)
+	}
+
+	a, b, c := ids[0], ids[1], ids[2]
+
+	err = m.Movies.Delete(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, want ...int64) {
+		t.Helper()
+
+		items, err := m.Collections.GetItems(collection.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []int64
+		for _, item := range items {
+			got = append(got, item.Movie.ID)
+		}
+
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got movies %v; want %v", name, got, want)
+		}
+	}
+
+	// The deleted movie is hidden, so the client only lists the other two.
+	err = m.Collections.Reorder(collection.ID, []int64{c, a})
+	if err != nil {
+		t.Fatalf("reorder without the deleted movie: %v", err)
+	}
+
+	check("after reorder", c, a)
+
+	err = m.Collections.Reorder(collection.ID, []int64{c, b, a})
+	if !errors.Is(err, ErrEditConflict) {
+		t.Errorf("reorder listing the deleted movie: got error %v; want %v", err, ErrEditConflict)
+	}
+
+	err = m.Movies.Restore(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check("after restore", c, a, b)
+}
+
+func TestDeleteUserKeepsSharedCollections(t *testing.T) {
+	m := New(testdb.New(t))
+
+	defer SetPasswordHasher(passwordHasher)
+	SetPasswordHasher(BcryptHasher{Cost: 4})
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.User.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slugs := map[string]string{}
+
+	for _, visibility := range []string{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate} {
+		collection := &Collection{UserID: &user.ID, Title: visibility, Visibility: visibility}
+
+		err := m.Collections.Insert(collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		slugs[visibility] = collection.Slug
+	}
+
+	err = m.User.Delete(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for visibility, kept := range map[string]bool{VisibilityPublic: true, VisibilityUnlisted: true, VisibilityPrivate: false} {
+		collection, err := m.Collections.GetBySlug(slugs[visibility])
+
+		switch {
+		case kept && err != nil:
+			t.Errorf("%s collection: got error %v; want it kept", visibility, err)
+		case kept && collection.UserID != nil:
+			t.Errorf("%s collection: still owned by user %d", visibility, *collection.UserID)
+		case !kept && !errors.Is(err, ErrRecordNotFound):
+			t.Errorf("%s collection: got error %v; want %v", visibility, err, ErrRecordNotFound)
+		}
+	}
+}
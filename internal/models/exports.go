@@ -0,0 +1,99 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DataExport is a ZIP archive of everything stored about a user, kept until it is
+// downloaded or replaced by a newer export.
+type DataExport struct {
+	UserID    int64
+	CreatedAt time.Time
+	Archive   []byte
+}
+
+type DataExportModel struct {
+	DB *sql.DB
+}
+
+// Replace() stores the export of a user, overwriting any previous one.
+func (m DataExportModel) Replace(export *DataExport) error {
+	query := `
+		INSERT INTO data_exports (user_id, archive)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET archive = EXCLUDED.archive, created_at = NOW()
+		RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, export.UserID, export.Archive).Scan(&export.CreatedAt)
+}
+
+// Consume() exchanges an unexpired download token for the export it was issued for,
+// deleting the token, any other download tokens of the user and the export in one
+// transaction. Deleting the token first makes this atomic: when the same link is
+// used twice concurrently, only one request gets a row back and the other gets
+// ErrRecordNotFound, as it would for an invalid or expired token.
+func (m DataExportModel) Consume(tokenPlaintext string) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND expiry > $3
+		RETURNING user_id`
+
+	var userID int64
+
+	err = tx.QueryRowContext(ctx, query, HashToken(tokenPlaintext), ScopeDataExport, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`, ScopeDataExport, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		DELETE FROM data_exports
+		WHERE user_id = $1
+		RETURNING user_id, created_at, archive`
+
+	var export DataExport
+
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&export.UserID, &export.CreatedAt, &export.Archive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
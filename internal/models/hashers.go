@@ -0,0 +1,185 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher is an algorithm for hashing passwords. Hashes carry their own
+// parameters, so a hasher can tell whether a hash made by it is up to date.
+type PasswordHasher interface {
+	Hash(plaintext string) ([]byte, error)
+	Matches(hash []byte, plaintext string) (bool, error)
+	// Owns reports whether the hash was produced by this algorithm.
+	Owns(hash []byte) bool
+	// Current reports whether the hash uses this hasher's configured parameters.
+	Current(hash []byte) bool
+	// MaxLength is the longest plaintext, in bytes, the algorithm fully uses.
+	MaxLength() int
+}
+
+// passwordHasher is used for all new hashes. Existing hashes are checked with
+// whichever of the known hashers owns them.
+var passwordHasher PasswordHasher = BcryptHasher{Cost: 12}
+
+// SetPasswordHasher configures the hasher used for new password hashes. It should
+// only be called during startup.
+func SetPasswordHasher(h PasswordHasher) {
+	passwordHasher = h
+}
+
+func hasherFor(hash []byte) (PasswordHasher, error) {
+	for _, h := range []PasswordHasher{passwordHasher, Argon2idHasher{}, BcryptHasher{}} {
+		if h.Owns(hash) {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownHashFormat
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), h.Cost)
+}
+
+func (h BcryptHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) Owns(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$2")
+}
+
+func (h BcryptHasher) Current(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err == nil && cost == h.Cost
+}
+
+func (h BcryptHasher) MaxLength() int {
+	return 72
+}
+
+// Argon2idHasher produces hashes in the PHC string format, for example
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, h.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Memory,
+		h.Iterations,
+		h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+func (h Argon2idHasher) Matches(hash []byte, plaintext string) (bool, error) {
+	params, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h Argon2idHasher) Owns(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$argon2id$")
+}
+
+func (h Argon2idHasher) Current(hash []byte) bool {
+	params, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	return params.memory == h.Memory &&
+		params.iterations == h.Iterations &&
+		params.parallelism == h.Parallelism &&
+		uint32(len(params.salt)) == h.SaltLength &&
+		uint32(len(params.key)) == h.KeyLength
+}
+
+func (h Argon2idHasher) MaxLength() int {
+	return 1024
+}
+
+func decodeArgon2id(hash []byte) (*argon2idParams, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, ErrUnknownHashFormat
+	}
+
+	var params argon2idParams
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism)
+	if err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	params.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, ErrUnknownHashFormat
+	}
+
+	return &params, nil
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateIdentity = errors.New("duplicate identity")
+
+// Identity links a user to an account at an external OpenID Connect provider, which
+// is identified by the provider's stable subject identifier rather than by email.
+type Identity struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// OIDCLogin is an authorization request that has been sent to a provider and is
+// waiting for the user to come back with a code.
+type OIDCLogin struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+func (m IdentityModel) Insert(identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, last_login_at`
+
+	args := []any{identity.UserID, identity.Provider, identity.Subject, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "user_identities_provider_subject_key"):
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InsertWithUser() creates a user together with their first identity, assigning them
+// the named roles, in a single transaction. A user created for an identity provider
+// login is therefore never left without the identity that logs them in.
+func (m IdentityModel) InsertWithUser(identity *Identity, user *User, roles ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users(name, email, password_hash, activated)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, user.Name, user.Email, user.Password.hash, user.Activated).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO users_roles
+		SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, user.ID, pq.Array(roles))
+	if err != nil {
+		return err
+	}
+
+	identity.UserID = user.ID
+
+	query = `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, last_login_at`
+
+	err = tx.QueryRowContext(ctx, query, identity.UserID, identity.Provider, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "user_identities_provider_subject_key"):
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetForLogin() looks up the identity for a provider subject and records the login,
+// keeping the stored email in step with the one the provider last reported.
+func (m IdentityModel) GetForLogin(provider, subject, email string) (*Identity, error) {
+	query := `
+		UPDATE user_identities
+		SET last_login_at = NOW(), email = COALESCE(NULLIF($3, ''), email)
+		WHERE provider = $1 AND subject = $2
+		RETURNING id, user_id, created_at, provider, subject, email, last_login_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var identity Identity
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject, email).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.CreatedAt,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.LastLoginAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &identity, nil
+}
+
+func (m IdentityModel) GetAllForUser(userID int64) ([]*Identity, error) {
+	query := `
+		SELECT id, user_id, created_at, provider, subject, email, last_login_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+
+		err = rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.CreatedAt,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.LastLoginAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// InsertLogin() stores a pending authorization request under the hash of its state
+// value.
+func (m IdentityModel) InsertLogin(state string, login *OIDCLogin, ttl time.Duration) error {
+	query := `
+		INSERT INTO oidc_logins (state_hash, provider, nonce, code_verifier, expiry)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	args := []any{HashToken(state), login.Provider, login.Nonce, login.CodeVerifier, time.Now().Add(ttl)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ConsumeLogin() deletes and returns the unexpired authorization request for a state
+// value, so each one can only be completed once. Expired requests are cleaned up
+// along the way.
+func (m IdentityModel) ConsumeLogin(provider, state string) (*OIDCLogin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_logins WHERE expiry <= NOW()`)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND provider = $2
+		RETURNING provider, nonce, code_verifier`
+
+	var login OIDCLogin
+
+	err = m.DB.QueryRowContext(ctx, query, HashToken(state), provider).Scan(&login.Provider, &login.Nonce, &login.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &login, nil
+}
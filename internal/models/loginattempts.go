@@ -0,0 +1,91 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/throttle"
+)
+
+// LoginAttemptModel is a throttle.Store backed by the login_attempts table, so that
+// failed logins are counted across every instance of the API.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+func (m LoginAttemptModel) Get(key string) (throttle.Attempts, error) {
+	query := `
+		SELECT failures, last_failure
+		FROM login_attempts
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var attempts throttle.Attempts
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return throttle.Attempts{}, err
+	}
+
+	return attempts, nil
+}
+
+func (m LoginAttemptModel) RecordFailure(key string, window time.Duration) (throttle.Attempts, error) {
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failure < NOW() - make_interval(secs => $2) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure = NOW()
+		RETURNING failures, last_failure`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var attempts throttle.Attempts
+
+	err := m.DB.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&attempts.Failures, &attempts.LastFailure)
+	if err != nil {
+		return throttle.Attempts{}, err
+	}
+
+	return attempts, nil
+}
+
+func (m LoginAttemptModel) Reset(key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// DeleteExpired removes the history of keys whose last failure is older than cutoff,
+// returning how many were removed. The limiter ignores such rows anyway, so this only
+// keeps the table from growing with every address and IP that ever failed a login.
+func (m LoginAttemptModel) DeleteExpired(cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE last_failure < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/testdb"
+	"github.com/fayazp088/greenlight/internal/throttle"
+)
+
+func TestLoginAttemptStore(t *testing.T) {
+	m := New(testdb.New(t))
+
+	// age moves the last failure of a key into the past, as the store takes the
+	// time of a failure from the database clock.
+	age := func(key string, d time.Duration) {
+		t.Helper()
+
+		_, err := m.LoginAttempts.DB.Exec(`UPDATE login_attempts SET last_failure = last_failure - make_interval(secs => $2) WHERE key = $1`, key, d.Seconds())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failures := func(key string) int {
+		t.Helper()
+
+		attempts, err := m.LoginAttempts.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return attempts.Failures
+	}
+
+	for want := 1; want <= 3; want++ {
+		attempts, err := m.LoginAttempts.RecordFailure("ip:192.0.2.1", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if attempts.Failures != want {
+			t.Errorf("got %d failures; want %d", attempts.Failures, want)
+		}
+	}
+
+	if got := failures("ip:192.0.2.2"); got != 0 {
+		t.Errorf("unknown key: got %d failures; want 0", got)
+	}
+
+	// A failure after the window has passed starts counting again.
+	age("ip:192.0.2.1", 2*time.Hour)
+
+	attempts, err := m.LoginAttempts.RecordFailure("ip:192.0.2.1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts.Failures != 1 {
+		t.Errorf("after the window: got %d failures; want 1", attempts.Failures)
+	}
+
+	err = m.LoginAttempts.Reset("ip:192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := failures("ip:192.0.2.1"); got != 0 {
+		t.Errorf("after reset: got %d failures; want 0", got)
+	}
+
+	// Only keys whose last failure is older than the cutoff are purged.
+	for _, key := range []string{"email:old@example.com", "email:new@example.com"} {
+		_, err := m.LoginAttempts.RecordFailure(key, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	age("email:old@example.com", 2*time.Hour)
+
+	purged, err := m.LoginAttempts.DeleteExpired(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 1 || failures("email:old@example.com") != 0 || failures("email:new@example.com") != 1 {
+		t.Errorf("purged %d keys; want only the old one", purged)
+	}
+}
+
+func TestLimiterWithLoginAttemptStore(t *testing.T) {
+	m := New(testdb.New(t))
+
+	limiter := throttle.NewLimiter(m.LoginAttempts, 2, time.Minute, time.Hour)
+
+	for i, want := range []bool{false, true} {
+		lockedOut, err := limiter.Fail("email:alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lockedOut != want {
+			t.Errorf("failure %d: got locked out %t; want %t", i+1, lockedOut, want)
+		}
+	}
+
+	wait, err := limiter.Check("email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The database and test clocks may differ slightly, so only check that the
+	// wait is close to the full lockout.
+	if wait < 59*time.Minute || wait > time.Hour+time.Minute {
+		t.Errorf("got wait %v; want about %v", wait, time.Hour)
+	}
+}
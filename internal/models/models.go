@@ -11,9 +11,22 @@ var (
 )
 
 type Models struct {
-	Movies MovieModel
-	User   UserModel
-	Tokens TokenModel
+	Movies        MovieModel
+	User          UserModel
+	Tokens        TokenModel
+	Permissions   PermissionModel
+	ApiKeys       ApiKeyModel
+	TwoFactor     TwoFactorModel
+	LoginAttempts LoginAttemptModel
+	DataExports   DataExportModel
+	Identities    IdentityModel
+	Roles         RoleModel
+	Reviews       ReviewModel
+	Watchlist     WatchlistModel
+	Watched       WatchedModel
+	People        PersonModel
+	Credits       CreditModel
+	Collections   CollectionModel
 }
 
 func New(db *sql.DB) Models {
@@ -27,5 +40,44 @@ func New(db *sql.DB) Models {
 		Tokens: TokenModel{
 			DB: db,
 		},
+		Permissions: PermissionModel{
+			DB: db,
+		},
+		ApiKeys: ApiKeyModel{
+			DB: db,
+		},
+		TwoFactor: TwoFactorModel{
+			DB: db,
+		},
+		LoginAttempts: LoginAttemptModel{
+			DB: db,
+		},
+		DataExports: DataExportModel{
+			DB: db,
+		},
+		Identities: IdentityModel{
+			DB: db,
+		},
+		Roles: RoleModel{
+			DB: db,
+		},
+		Reviews: ReviewModel{
+			DB: db,
+		},
+		Watchlist: WatchlistModel{
+			DB: db,
+		},
+		Watched: WatchedModel{
+			DB: db,
+		},
+		People: PersonModel{
+			DB: db,
+		},
+		Credits: CreditModel{
+			DB: db,
+		},
+		Collections: CollectionModel{
+			DB: db,
+		},
 	}
 }
@@ -21,6 +21,16 @@ type Movie struct {
 	Runtime   data.Runtime `json:"runtime,omitempty"`
 	Genres    []string     `json:"genres,omitempty"`
 	Version   int32        `json:"version"`
+
+	// The average and number of review ratings, computed when the movie is read.
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int64   `json:"rating_count"`
+
+	// Credits are only loaded for single movie responses.
+	Credits []*Credit `json:"credits,omitempty"`
+
+	// DeletedAt is set while the movie is soft deleted and can still be restored.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -41,6 +51,13 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// movieRatingsQuery aggregates the review ratings of the movie in the outer query. Its
+// rating column is also what movie lists are sorted by for sort=rating.
+const movieRatingsQuery = `
+	SELECT COALESCE(round(avg(reviews.rating), 1), 0)::float8 AS rating, count(reviews.id) AS rating_count
+	FROM reviews
+	WHERE reviews.movie_id = movies.id`
+
 type MovieModel struct {
 	DB *sql.DB
 }
@@ -64,7 +81,7 @@ func (m MovieModel) Update(movie *Movie) error {
 	query := `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 		RETURNING version`
 
 	args := []any{
@@ -92,21 +109,44 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) List(title string, genres []string, filter data.Filters) ([]*Movie, data.Metadata, error) {
+// List() returns a page of movies matching the title, genres and credit filters. When
+// excludeWatchedBy is a user ID, movies that user has marked as watched are left out.
+// Soft deleted movies are only listed when includeDeleted is true.
+func (m MovieModel) List(title string, genres []string, credits []CreditFilter, excludeWatchedBy int64, includeDeleted bool, filter data.Filters) ([]*Movie, data.Metadata, error) {
+
+	args := []any{title, pq.Array(genres), filter.Limit(), filter.Offset(), excludeWatchedBy, includeDeleted}
+
+	// Each credit filter adds its own condition, so ?director=...&actor=... only
+	// matches movies with both.
+	creditConditions := ""
+
+	for _, credit := range credits {
+		args = append(args, credit.Role, credit.PersonID, credit.Name)
+		n := len(args)
+
+		creditConditions += fmt.Sprintf(`
+		AND EXISTS (
+			SELECT 1 FROM movie_credits
+			INNER JOIN people ON people.id = movie_credits.person_id
+			WHERE movie_credits.movie_id = movies.id AND movie_credits.role = $%d
+			AND (people.id = $%d OR ($%d = 0 AND to_tsvector('simple', people.name) @@ plainto_tsquery('simple', $%d)))
+		)`, n-2, n-1, n-1, n)
+	}
 
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, rating, rating_count, deleted_at
 		FROM movies
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		CROSS JOIN LATERAL (%s) AS ratings
+		WHERE (deleted_at IS NULL OR $6)
+		AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (genres @> $2 OR $2 = '{}')
+		AND NOT EXISTS (SELECT 1 FROM watched WHERE watched.movie_id = movies.id AND watched.user_id = $5)%s
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filter.SortColumn(), filter.SortDirection())
+		LIMIT $3 OFFSET $4`, movieRatingsQuery, creditConditions, filter.SortColumn(), filter.SortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filter.Limit(), filter.Offset()}
-
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 
 	if err != nil {
@@ -130,6 +170,9 @@ func (m MovieModel) List(title string, genres []string, filter data.Filters) ([]
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.DeletedAt,
 		)
 		if err != nil {
 			return nil, data.Metadata{}, err
@@ -148,15 +191,26 @@ func (m MovieModel) List(title string, genres []string, filter data.Filters) ([]
 	return movies, metadata, nil
 }
 
+// Get() returns a movie unless it has been soft deleted.
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.get(id, false)
+}
+
+// GetIncludingDeleted() returns a movie whether or not it has been soft deleted.
+func (m MovieModel) GetIncludingDeleted(id int64) (*Movie, error) {
+	return m.get(id, true)
+}
+
+func (m MovieModel) get(id int64, includeDeleted bool) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `
-			SELECT id, created_at, title, year, runtime, genres, version
+	query := fmt.Sprintf(`
+			SELECT id, created_at, title, year, runtime, genres, version, rating, rating_count, deleted_at
 			FROM movies
-			WHERE id = $1`
+			CROSS JOIN LATERAL (%s) AS ratings
+			WHERE id = $1 AND (deleted_at IS NULL OR $2)`, movieRatingsQuery)
 
 	var movie Movie
 
@@ -164,7 +218,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	defer cancel()
 
 	// Pass &movie to Scan to store the result into the struct
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, id, includeDeleted).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
 		&movie.Title,
@@ -172,6 +226,9 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount,
+		&movie.DeletedAt,
 	)
 
 	if err != nil {
@@ -186,6 +243,8 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
+// Delete() soft deletes a movie. It disappears from Get() and List() but stays in the
+// table, with its reviews and credits, until it is restored or purged.
 func (m MovieModel) Delete(id int64) error {
 
 	if id < 1 {
@@ -193,15 +252,35 @@ func (m MovieModel) Delete(id int64) error {
 	}
 
 	query := `
-		DELETE FROM
-		movies
-		WHERE id = $1
+		UPDATE movies
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
+	return m.exec(query, id)
+}
+
+// Restore() undoes the soft deletion of a movie.
+func (m MovieModel) Restore(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	return m.exec(query, id)
+}
+
+// exec runs a statement affecting a single movie, returning ErrRecordNotFound if no
+// row matched.
+func (m MovieModel) exec(query string, args ...any) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, args...)
 
 	if err != nil {
 		return err
@@ -218,3 +297,21 @@ func (m MovieModel) Delete(id int64) error {
 
 	return nil
 }
+
+// Purge() permanently deletes the movies that were soft deleted before the cutoff and
+// returns how many there were.
+func (m MovieModel) Purge(cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM movies
+		WHERE deleted_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/fayazp088/greenlight/internal/validator"
+)
+
+// BreachedPasswords reports whether a password is known from a data breach.
+type BreachedPasswords interface {
+	Contains(plaintext string) (bool, error)
+}
+
+// PasswordPolicy is the set of rules new passwords must satisfy. It is only applied
+// when a password is chosen, never at login, so tightening it doesn't lock anybody
+// out of their existing password.
+type PasswordPolicy struct {
+	MinLength            int
+	RequireLower         bool
+	RequireUpper         bool
+	RequireDigit         bool
+	RequireSymbol        bool
+	DisallowPersonalInfo bool
+	Breached             BreachedPasswords
+}
+
+var passwordPolicy = PasswordPolicy{
+	MinLength:            8,
+	DisallowPersonalInfo: true,
+}
+
+// SetPasswordPolicy configures the policy for new passwords. It should only be called
+// during startup.
+func SetPasswordPolicy(p PasswordPolicy) {
+	passwordPolicy = p
+}
+
+// ValidateNewPassword checks a newly chosen password against the basic length limits
+// and the configured policy, adding any failure to the validator under key. The user
+// is used to reject passwords made from the account's own email address or name. An
+// error is only returned if the breached password check itself fails.
+func ValidateNewPassword(v *validator.Validator, key, plaintext string, user *User) error {
+	v.Check(plaintext != "", key, "must be provided")
+	v.Check(len(plaintext) >= passwordPolicy.MinLength, key, fmt.Sprintf("must be at least %d bytes long", passwordPolicy.MinLength))
+	v.Check(len(plaintext) <= passwordHasher.MaxLength(), key, fmt.Sprintf("must not be more than %d bytes long", passwordHasher.MaxLength()))
+
+	if passwordPolicy.RequireLower {
+		v.Check(strings.IndexFunc(plaintext, unicode.IsLower) >= 0, key, "must contain a lowercase letter")
+	}
+	if passwordPolicy.RequireUpper {
+		v.Check(strings.IndexFunc(plaintext, unicode.IsUpper) >= 0, key, "must contain an uppercase letter")
+	}
+	if passwordPolicy.RequireDigit {
+		v.Check(strings.IndexFunc(plaintext, unicode.IsDigit) >= 0, key, "must contain a digit")
+	}
+	if passwordPolicy.RequireSymbol {
+		v.Check(strings.IndexFunc(plaintext, isSymbol) >= 0, key, "must contain a symbol")
+	}
+
+	if passwordPolicy.DisallowPersonalInfo && user != nil {
+		localPart, _, _ := strings.Cut(user.Email, "@")
+
+		v.Check(!strings.EqualFold(plaintext, user.Email), key, "must not be the same as your email address")
+		v.Check(localPart == "" || !strings.EqualFold(plaintext, localPart), key, "must not be the same as your email address")
+		v.Check(user.Name == "" || !strings.EqualFold(plaintext, user.Name), key, "must not be the same as your name")
+	}
+
+	// Skip the lookup if the password has already failed, since the client needs to
+	// choose a different one anyway.
+	if _, failed := v.Errors[key]; failed || passwordPolicy.Breached == nil {
+		return nil
+	}
+
+	breached, err := passwordPolicy.Breached.Contains(plaintext)
+	if err != nil {
+		return err
+	}
+
+	v.Check(!breached, key, "has appeared in a data breach, please choose a different password")
+
+	return nil
+}
+
+func isSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
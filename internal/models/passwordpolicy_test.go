@@ -0,0 +1,154 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fayazp088/greenlight/internal/breached"
+	"github.com/fayazp088/greenlight/internal/validator"
+)
+
+func TestNewPasswordUsesConfiguredMinLength(t *testing.T) {
+	defer SetPasswordPolicy(passwordPolicy)
+	SetPasswordPolicy(PasswordPolicy{MinLength: 6})
+
+	user := &User{Name: "Alice", Email: "alice@example.com"}
+
+	err := user.Password.Set("abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := validator.New()
+
+	ValidateUser(v, user)
+
+	err = ValidateNewPassword(v, "password", "abc123", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !v.Valid() {
+		t.Errorf("6 byte password with a minimum of 6: got errors %v", v.Errors)
+	}
+
+	v = validator.New()
+
+	err = ValidateNewPassword(v, "password", "abc12", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := v.Errors["password"], "must be at least 6 bytes long"; got != want {
+		t.Errorf("5 byte password: got error %q; want %q", got, want)
+	}
+}
+
+func TestNewPasswordCharacterClasses(t *testing.T) {
+	defer SetPasswordPolicy(passwordPolicy)
+	SetPasswordPolicy(PasswordPolicy{
+		MinLength:     8,
+		RequireLower:  true,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	})
+
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"Str0ng-pass", ""},
+		{"STR0NG-PASS", "must contain a lowercase letter"},
+		{"str0ng-pass", "must contain an uppercase letter"},
+		{"Strong-pass", "must contain a digit"},
+		{"Str0ngpass", "must contain a symbol"},
+		{"Ünïcödé-9x", ""},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+
+		err := ValidateNewPassword(v, "password", tt.password, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := v.Errors["password"]; got != tt.want {
+			t.Errorf("%q: got error %q; want %q", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestNewPasswordPersonalInfo(t *testing.T) {
+	defer SetPasswordPolicy(passwordPolicy)
+	SetPasswordPolicy(PasswordPolicy{MinLength: 8, DisallowPersonalInfo: true})
+
+	user := &User{Name: "Alice Liddell", Email: "wonderland@example.com"}
+
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"WONDERLAND@example.com", "must not be the same as your email address"},
+		{"Wonderland", "must not be the same as your email address"},
+		{"alice liddell", "must not be the same as your name"},
+		{"through the looking glass", ""},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+
+		err := ValidateNewPassword(v, "password", tt.password, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := v.Errors["password"]; got != tt.want {
+			t.Errorf("%q: got error %q; want %q", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestNewPasswordBreached(t *testing.T) {
+	// A single range file listing the SHA-1 hash of "password1234", which is
+	// E6B6AFBD6D76BB5D2041542D7D2E3FAC5BB05593.
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "E6B6A.txt"), []byte("FBD6D76BB5D2041542D7D2E3FAC5BB05593:42\r\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges, err := breached.NewRangeDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer SetPasswordPolicy(passwordPolicy)
+	SetPasswordPolicy(PasswordPolicy{MinLength: 8, Breached: ranges})
+
+	tests := []struct {
+		password string
+		want     string
+	}{
+		{"password1234", "has appeared in a data breach, please choose a different password"},
+		{"password1235", ""},
+		// A password that already failed isn't looked up at all.
+		{"short", "must be at least 8 bytes long"},
+	}
+
+	for _, tt := range tests {
+		v := validator.New()
+
+		err := ValidateNewPassword(v, "password", tt.password, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := v.Errors["password"]; got != tt.want {
+			t.Errorf("%q: got error %q; want %q", tt.password, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/fayazp088/greenlight/internal/testdb"
+)
+
+func TestGetAllForUserCombinesRolesAndGrants(t *testing.T) {
+	m := New(testdb.New(t))
+
+	defer SetPasswordHasher(passwordHasher)
+	SetPasswordHasher(BcryptHasher{Cost: 4})
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.User.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, got func(int64) (Permissions, error), want ...string) {
+		t.Helper()
+
+		permissions, err := got(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(permissions, Permissions(want)) {
+			t.Errorf("%s: got %v; want %v", name, permissions, want)
+		}
+	}
+
+	err = m.Roles.AddForUser(user.ID, "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A permission held both directly and through a role is listed once.
+	err = m.Permissions.AddForUser(user.ID, "movies:read", "users:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check("effective", m.Permissions.GetAllForUser, "movies:read", "users:admin")
+	check("granted", m.Permissions.GetGrantedForUser, "movies:read", "users:admin")
+
+	// Revoking the direct grant leaves the permission the role provides.
+	err = m.Permissions.RemoveForUser(user.ID, "movies:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check("effective after revoking grant", m.Permissions.GetAllForUser, "movies:read", "users:admin")
+	check("granted after revoking grant", m.Permissions.GetGrantedForUser, "users:admin")
+
+	err = m.Roles.AddForUser(user.ID, "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check("effective with editor role", m.Permissions.GetAllForUser, "movies:read", "movies:write", "users:admin")
+
+	// Changing a role's permissions changes what its users hold.
+	viewer, err := m.Roles.GetByName("viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viewer.Permissions = Permissions{"movies:read", "reviews:moderate"}
+
+	err = m.Roles.Update(viewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check("effective after role update", m.Permissions.GetAllForUser, "movies:read", "movies:write", "reviews:moderate", "users:admin")
+
+	err = m.Roles.RemoveForUser(user.ID, "editor", "viewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check("effective without roles", m.Permissions.GetAllForUser, "users:admin")
+}
@@ -6,14 +6,27 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+	ScopeMFAPending     = "mfa_pending"
+	ScopeEmailChange    = "email-change"
+	ScopeDataExport     = "data-export"
+	ScopeMagicLink      = "magic-link"
+)
+
+var (
+	ErrTokenReused = errors.New("token reused")
 )
 
 type Token struct {
@@ -22,6 +35,33 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+	IP        string    `json:"-"`
+	FamilyID  string    `json:"-"`
+}
+
+// Session describes a token family as shown to its owner. Its id is the family id,
+// which stays the same across refreshes and can't be used to authenticate, so
+// sessions can be listed and revoked without exposing any token.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
+}
+
+// TokenMetadata describes a token of any scope without exposing its hash, as included
+// in a user's data export.
+type TokenMetadata struct {
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
 }
 
 type TokenModel struct {
@@ -39,13 +79,27 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// NewInFamily() creates and inserts a token belonging to an existing token family.
+func (m TokenModel) NewInFamily(userID int64, ttl time.Duration, scope, familyID, userAgent, ip string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	token.FamilyID = familyID
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	err = m.Insert(token)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
 
 	query :=
-		`INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
+		`INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip, family_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid)`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP, token.FamilyID}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -65,11 +119,360 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	return err
 }
 
+// DeleteByHash() deletes the token with the given SHA-256 hash, along with any other
+// tokens in its family (such as the refresh token issued with it).
+func (m TokenModel) DeleteByHash(hash []byte) error {
+
+	query :=
+		`DELETE FROM tokens
+		WHERE hash = $1
+		OR family_id = (SELECT family_id FROM tokens WHERE hash = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetUnexpired() looks up an unexpired token of the given scope by its plaintext
+// value, regardless of whether it has already been used.
+func (m TokenModel) GetUnexpired(scope, tokenPlaintext string) (*Token, error) {
+
+	query :=
+		`SELECT hash, user_id, expiry, scope, user_agent, ip, COALESCE(family_id::text, '')
+		FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND expiry > $3`
+
+	args := []any{HashToken(tokenPlaintext), scope, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := Token{Plaintext: tokenPlaintext}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.UserAgent,
+		&token.IP,
+		&token.FamilyID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// Consume() deletes an unexpired token of the given scope by its plaintext value and
+// returns it. Looking the token up and deleting it is a single statement, so when the
+// same token is presented concurrently only one request gets it back; the others get
+// ErrRecordNotFound, as they would for an invalid or expired token.
+func (m TokenModel) Consume(scope, tokenPlaintext string) (*Token, error) {
+
+	query :=
+		`DELETE FROM tokens
+		WHERE hash = $1
+		AND scope = $2
+		AND expiry > $3
+		RETURNING hash, user_id, expiry, scope, user_agent, ip, COALESCE(family_id::text, '')`
+
+	args := []any{HashToken(tokenPlaintext), scope, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := Token{Plaintext: tokenPlaintext}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Expiry,
+		&token.Scope,
+		&token.UserAgent,
+		&token.IP,
+		&token.FamilyID,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &token, nil
+}
+
+// MarkUsed() flags a single-use token as consumed. The used_at IS NULL predicate makes
+// this atomic: if the token was already used (possibly by a concurrent request) then
+// ErrTokenReused is returned.
+func (m TokenModel) MarkUsed(hash []byte) error {
+
+	query :=
+		`UPDATE tokens
+		SET used_at = NOW()
+		WHERE hash = $1 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTokenReused
+	}
+
+	return nil
+}
+
+// DeleteFamily() deletes every token, of any scope, in a token family.
+func (m TokenModel) DeleteFamily(familyID string) error {
+
+	query :=
+		`DELETE FROM tokens
+		WHERE family_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// DeleteFamilyScope() deletes the tokens of one scope in a token family.
+func (m TokenModel) DeleteFamilyScope(familyID, scope string) error {
+
+	query :=
+		`DELETE FROM tokens
+		WHERE family_id = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, familyID, scope)
+	return err
+}
+
+// DeleteSessionsForUserExcept() deletes the authentication and refresh tokens of
+// every session of a user except the one in token family keepFamilyID. Pass an empty
+// keepFamilyID to delete all of them.
+func (m TokenModel) DeleteSessionsForUserExcept(userID int64, keepFamilyID string) error {
+
+	query :=
+		`DELETE FROM tokens
+		WHERE user_id = $1
+		AND scope = ANY($2)
+		AND ($3 = '' OR family_id IS DISTINCT FROM NULLIF($3, '')::uuid)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), keepFamilyID)
+	return err
+}
+
+// Touch() records that the token with the given hash has just been used. To avoid a
+// write on every request, last_used_at is only bumped once it is a minute old.
+func (m TokenModel) Touch(hash []byte) error {
+
+	query :=
+		`UPDATE tokens
+		SET last_used_at = NOW()
+		WHERE hash = $1
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, hash)
+	return err
+}
+
+// GetSessionsForUser() returns the sessions of a user, newest first. A session is a
+// token family that still holds an unexpired, unused refresh token, so it outlives the
+// short-lived authentication tokens rotated within it and keeps the same id. It is
+// shown with the user agent and IP of its latest token, and the family matching
+// currentFamilyID is flagged as current.
+func (m TokenModel) GetSessionsForUser(userID int64, currentFamilyID string) ([]*Session, error) {
+
+	query :=
+		`SELECT family_id,
+			MIN(created_at),
+			GREATEST(MAX(last_used_at), MAX(created_at)),
+			MAX(expiry) FILTER (WHERE scope = $2 AND used_at IS NULL),
+			(array_agg(user_agent ORDER BY created_at DESC))[1],
+			(array_agg(ip ORDER BY created_at DESC))[1],
+			COALESCE(family_id = NULLIF($4, '')::uuid, false)
+		FROM tokens
+		WHERE user_id = $1
+		AND scope = ANY($3)
+		AND family_id IS NOT NULL
+		GROUP BY family_id
+		HAVING bool_or(scope = $2 AND used_at IS NULL AND expiry > NOW())
+		ORDER BY MIN(created_at) DESC`
+
+	args := []any{userID, ScopeRefresh, pq.Array([]string{ScopeAuthentication, ScopeRefresh}), currentFamilyID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err = rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// GetMetadataForUser() returns the metadata of every token of a user, newest first.
+func (m TokenModel) GetMetadataForUser(userID int64) ([]*TokenMetadata, error) {
+
+	query :=
+		`SELECT scope, created_at, last_used_at, expiry, user_agent, ip
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*TokenMetadata{}
+
+	for rows.Next() {
+		var token TokenMetadata
+
+		err = rows.Scan(
+			&token.Scope,
+			&token.CreatedAt,
+			&token.LastUsedAt,
+			&token.Expiry,
+			&token.UserAgent,
+			&token.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeleteSessionForUser() ends a session by deleting every authentication and refresh
+// token of its family. The user id is part of the predicate so that users can only
+// revoke their own sessions.
+func (m TokenModel) DeleteSessionForUser(familyID string, userID int64) error {
+
+	query :=
+		`DELETE FROM tokens
+		WHERE family_id = $1
+		AND user_id = $2
+		AND scope = ANY($3)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, familyID, userID, pq.Array([]string{ScopeAuthentication, ScopeRefresh}))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// HashToken returns the SHA-256 hash of a plaintext token, in the same form that is
+// stored in the hash column of the tokens table.
+func HashToken(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
+func ValidateSessionID(v *validator.Validator, id string) {
+	v.Check(validator.Matches(id, validator.UUIDRX), "id", "must be a valid session id")
+}
+
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Create a Token instance containing the user ID, expiry, and scope information.
 	// Notice that we add the provided ttl (time-to-live) duration parameter to the
@@ -100,3 +503,19 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	token.Hash = hash[:]
 	return token, nil
 }
+
+// NewFamilyID returns a random (version 4) UUID used to group the tokens that were
+// issued for the same login, such as an authentication token and its refresh tokens.
+func NewFamilyID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
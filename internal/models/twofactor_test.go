@@ -0,0 +1,150 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/testdb"
+)
+
+// insertTwoFactorUser creates a user with two-factor authentication enabled and
+// returns their recovery codes.
+func insertTwoFactorUser(t *testing.T, m Models) (*User, []string) {
+	t.Helper()
+
+	defer SetPasswordHasher(passwordHasher)
+	SetPasswordHasher(BcryptHasher{Cost: 4})
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.User.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.TwoFactor.SetPendingSecret(user.ID, []byte("sealed secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := m.TwoFactor.Enable(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, codes
+}
+
+func TestConsumeStepRejectsReplay(t *testing.T) {
+	m := New(testdb.New(t))
+
+	user, _ := insertTwoFactorUser(t, m)
+
+	err := m.TwoFactor.ConsumeStep(user.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []int64{100, 99} {
+		err = m.TwoFactor.ConsumeStep(user.ID, step)
+		if !errors.Is(err, ErrTokenReused) {
+			t.Errorf("step %d after step 100: got error %v; want %v", step, err, ErrTokenReused)
+		}
+	}
+
+	err = m.TwoFactor.ConsumeStep(user.ID, 101)
+	if err != nil {
+		t.Errorf("step 101 after step 100: got error %v", err)
+	}
+}
+
+func TestConsumeRecoveryCode(t *testing.T) {
+	m := New(testdb.New(t))
+
+	user, codes := insertTwoFactorUser(t, m)
+
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(codes), recoveryCodeCount)
+	}
+
+	// Codes are accepted without the dash and in upper case.
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+
+	err := m.TwoFactor.ConsumeRecoveryCode(user.ID, typed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.TwoFactor.ConsumeRecoveryCode(user.ID, codes[0])
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("used code: got error %v; want %v", err, ErrRecordNotFound)
+	}
+
+	err = m.TwoFactor.ConsumeRecoveryCode(user.ID, "aaaaa-aaaaa")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("unknown code: got error %v; want %v", err, ErrRecordNotFound)
+	}
+
+	err = m.TwoFactor.ConsumeRecoveryCode(user.ID, codes[1])
+	if err != nil {
+		t.Errorf("unused code: got error %v", err)
+	}
+
+	// Enabling again replaces every code, used or not.
+	_, err = m.TwoFactor.Enable(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.TwoFactor.ConsumeRecoveryCode(user.ID, codes[2])
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("code from before re-enabling: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestConsumeTokenOnlyOnce(t *testing.T) {
+	m := New(testdb.New(t))
+
+	user, _ := insertTwoFactorUser(t, m)
+
+	token, err := m.Tokens.New(user.ID, 5*time.Minute, ScopeMFAPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Tokens.Consume(ScopeAuthentication, token.Plaintext)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("wrong scope: got error %v; want %v", err, ErrRecordNotFound)
+	}
+
+	consumed, err := m.Tokens.Consume(ScopeMFAPending, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if consumed.UserID != user.ID {
+		t.Errorf("got user %d; want %d", consumed.UserID, user.ID)
+	}
+
+	_, err = m.Tokens.Consume(ScopeMFAPending, token.Plaintext)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("second consume: got error %v; want %v", err, ErrRecordNotFound)
+	}
+
+	expired, err := m.Tokens.New(user.ID, -time.Minute, ScopeMFAPending)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Tokens.Consume(ScopeMFAPending, expired.Plaintext)
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("expired token: got error %v; want %v", err, ErrRecordNotFound)
+	}
+}
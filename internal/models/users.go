@@ -2,14 +2,13 @@ package models
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/fayazp088/greenlight/internal/data"
 	"github.com/fayazp088/greenlight/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -28,6 +27,9 @@ type User struct {
 
 	Activated bool `json:"activated"`
 	Version   int  `json:"-"`
+
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
 }
 
 type password struct {
@@ -36,7 +38,7 @@ type password struct {
 }
 
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := passwordHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -47,16 +49,19 @@ func (p *password) Set(plaintextPassword string) error {
 }
 
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	hasher, err := hasherFor(p.hash)
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
+		return false, err
 	}
-	return true, nil
+
+	return hasher.Matches(p.hash, plaintextPassword)
+}
+
+// NeedsRehash reports whether the hash was made with a different algorithm or
+// different parameters than the configured hasher, and so should be replaced the
+// next time the plaintext password is known.
+func (p *password) NeedsRehash() bool {
+	return !passwordHasher.Owns(p.hash) || !passwordHasher.Current(p.hash)
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -64,10 +69,13 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
 }
 
+// ValidatePasswordPlaintext checks a password given to confirm the user's identity,
+// such as at login. It doesn't apply the password policy, which is only for newly
+// chosen passwords (see ValidateNewPassword), so changing the policy never stops a
+// user from giving their existing password.
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.Check(len(password) <= passwordHasher.MaxLength(), "password", fmt.Sprintf("must not be more than %d bytes long", passwordHasher.MaxLength()))
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -75,11 +83,9 @@ func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
-	// If the plaintext password is not nil, call the standalone
-	// ValidatePasswordPlaintext() helper.
-	if user.Password.plaintext != nil {
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
-	}
+	// A newly set plaintext password isn't checked here: callers choosing a password
+	// check it against the policy with ValidateNewPassword(), which also needs the
+	// email address and name to have been set.
 	// If the password hash is ever nil, this will be due to a logic error in our
 	// codebase (probably because we forgot to set a password for the user). It's a
 	// useful sanity check to include here, but it's not a problem with the data
@@ -121,9 +127,47 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version, suspended_at, suspension_reason
+		FROM users
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, version, suspended_at, suspension_reason
 		FROM users
 		WHERE email = $1`
 
@@ -139,6 +183,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
 	)
 
 	if err != nil {
@@ -189,13 +235,193 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// List() returns a page of users matching the filters. Empty or nil filters match
+// every user; email matches any part of the address.
+func (m UserModel) List(email string, activated *bool, createdFrom, createdTo *time.Time, filters data.Filters) ([]*User, data.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, activated, version, suspended_at, suspension_reason
+		FROM users
+		WHERE (email ILIKE '%%' || $1 || '%%' OR $1 = '')
+		AND (activated = $2 OR $2 IS NULL)
+		AND (created_at >= $3 OR $3 IS NULL)
+		AND (created_at < $4 OR $4 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $5 OFFSET $6`, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{email, activated, createdFrom, createdTo, filters.Limit(), filters.Offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err = rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+			&user.SuspendedAt,
+			&user.SuspensionReason,
+		)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// SetSuspension() suspends a user with the given reason, or lifts the suspension when
+// suspend is false. The user's version is bumped so that concurrent edits made from
+// a stale copy fail with ErrEditConflict.
+func (m UserModel) SetSuspension(user *User, suspend bool, reason string) error {
+	query := `
+		UPDATE users
+		SET suspended_at = CASE WHEN $1 THEN NOW() ELSE NULL END,
+			suspension_reason = $2,
+			version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING suspended_at, suspension_reason, version`
+
+	if !suspend {
+		reason = ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, suspend, reason, user.ID, user.Version).Scan(
+		&user.SuspendedAt,
+		&user.SuspensionReason,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() removes a user account. Their tokens, API keys and permission grants are
+// removed with it through ON DELETE CASCADE, while content they authored references
+// users with ON DELETE SET NULL so that it is kept but no longer attributed to them.
+// Private collections, which nobody else could see, are the exception and are deleted
+// first; unlisted ones may have been shared by link and are kept like public ones.
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM collections WHERE user_id = $1 AND visibility = $2`, id, VisibilityPrivate)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// SetPendingEmail() stores the address a user has asked to change their email to,
+// until the change is confirmed. Pass an empty string to clear it.
+func (m UserModel) SetPendingEmail(userID int64, email string) error {
+	query := `
+		UPDATE users
+		SET pending_email = NULLIF($1, '')
+		WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, userID)
+	return err
+}
+
+// GetPendingEmail() returns the unconfirmed new email address of a user, or
+// ErrRecordNotFound if there is none.
+func (m UserModel) GetPendingEmail(userID int64) (string, error) {
+	query := `
+		SELECT pending_email
+		FROM users
+		WHERE id = $1 AND pending_email IS NOT NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var email string
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
+
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
-	// Remember that this returns a byte *array* with length 32, not a slice.
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	tokenHash := HashToken(tokenPlaintext)
 
 	query :=
-		`SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		`SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version,
+		users.suspended_at, users.suspension_reason
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -203,7 +429,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		AND tokens.scope = $2
 		AND tokens.expiry > $3`
 
-	args := []any{tokenHash[:], tokenScope, time.Now()}
+	args := []any{tokenHash, tokenScope, time.Now()}
 
 	var user User
 
@@ -218,6 +444,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.SuspendedAt,
+		&user.SuspensionReason,
 	)
 
 	if err != nil {
@@ -231,3 +459,13 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	return &user, nil
 }
+
+// IsSuspended checks if an administrator has suspended the user's account.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// IsAnonymous checks if a User instance is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/fayazp088/greenlight/internal/data"
+	"github.com/fayazp088/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// WatchlistEntry is a movie a user wants to watch.
+type WatchlistEntry struct {
+	Movie   *Movie    `json:"movie"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// WatchedEntry records that a user has watched a movie, when they last watched it, and
+// optionally their own rating of it. Unlike a review the rating is private.
+type WatchedEntry struct {
+	UserID    int64     `json:"-"`
+	Movie     *Movie    `json:"movie"`
+	WatchedOn time.Time `json:"watched_on"`
+	Rating    *int16    `json:"rating"`
+}
+
+func ValidateWatchedEntry(v *validator.Validator, entry *WatchedEntry) {
+	v.Check(!entry.WatchedOn.After(time.Now()), "watched_on", "must not be in the future")
+	v.Check(entry.WatchedOn.Year() >= 1888, "watched_on", "must be greater than 1888")
+
+	if entry.Rating != nil {
+		v.Check(*entry.Rating >= 1 && *entry.Rating <= 10, "rating", "must be between 1 and 10")
+	}
+}
+
+// watchlistMovieColumns are the movie columns selected for watchlist and watched
+// entries. The rating aggregates are renamed so they can't clash with the personal
+// rating of a watched entry.
+const watchlistMovieColumns = `movies.id, movies.created_at, movies.title, movies.year, movies.runtime, movies.genres, movies.version,
+		ratings.average_rating, ratings.rating_count`
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// Add() puts a movie on a user's watchlist. Adding a movie that is already on it
+// leaves the entry unchanged.
+func (m WatchlistModel) Add(userID, movieID int64) error {
+	query := `
+		INSERT INTO watchlist (user_id, movie_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	return err
+}
+
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	query := `
+		DELETE FROM watchlist
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m WatchlistModel) GetAllForUser(userID int64, filters data.Filters) ([]*WatchlistEntry, data.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s, watchlist.added_at
+		FROM watchlist
+		INNER JOIN movies ON movies.id = watchlist.movie_id
+		CROSS JOIN LATERAL (%s) AS ratings (average_rating, rating_count)
+		WHERE watchlist.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, movies.id ASC
+		LIMIT $2 OFFSET $3`, watchlistMovieColumns, movieRatingsQuery, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.Limit(), filters.Offset())
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		entry := WatchlistEntry{Movie: &Movie{}}
+
+		err = rows.Scan(
+			&totalRecords,
+			&entry.Movie.ID,
+			&entry.Movie.CreatedAt,
+			&entry.Movie.Title,
+			&entry.Movie.Year,
+			&entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres),
+			&entry.Movie.Version,
+			&entry.Movie.AverageRating,
+			&entry.Movie.RatingCount,
+			&entry.AddedAt,
+		)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+type WatchedModel struct {
+	DB *sql.DB
+}
+
+// Mark() records that the user has watched the movie, replacing the date and rating
+// of any earlier entry. The movie comes off the user's watchlist at the same time.
+func (m WatchedModel) Mark(entry *WatchedEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO watched (user_id, movie_id, watched_on, rating)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, movie_id) DO UPDATE
+		SET watched_on = EXCLUDED.watched_on, rating = EXCLUDED.rating`
+
+	_, err = tx.ExecContext(ctx, query, entry.UserID, entry.Movie.ID, entry.WatchedOn, entry.Rating)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM watchlist WHERE user_id = $1 AND movie_id = $2`, entry.UserID, entry.Movie.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m WatchedModel) Remove(userID, movieID int64) error {
+	query := `
+		DELETE FROM watched
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m WatchedModel) GetAllForUser(userID int64, filters data.Filters) ([]*WatchedEntry, data.Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s, watched.user_id, watched.watched_on, watched.rating
+		FROM watched
+		INNER JOIN movies ON movies.id = watched.movie_id
+		CROSS JOIN LATERAL (%s) AS ratings (average_rating, rating_count)
+		WHERE watched.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s NULLS LAST, movies.id ASC
+		LIMIT $2 OFFSET $3`, watchlistMovieColumns, movieRatingsQuery, filters.SortColumn(), filters.SortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.Limit(), filters.Offset())
+	if err != nil {
+		return nil, data.Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchedEntry{}
+
+	for rows.Next() {
+		entry := WatchedEntry{Movie: &Movie{}}
+
+		err = rows.Scan(
+			&totalRecords,
+			&entry.Movie.ID,
+			&entry.Movie.CreatedAt,
+			&entry.Movie.Title,
+			&entry.Movie.Year,
+			&entry.Movie.Runtime,
+			pq.Array(&entry.Movie.Genres),
+			&entry.Movie.Version,
+			&entry.Movie.AverageRating,
+			&entry.Movie.RatingCount,
+			&entry.UserID,
+			&entry.WatchedOn,
+			&entry.Rating,
+		)
+		if err != nil {
+			return nil, data.Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, data.Metadata{}, err
+	}
+
+	metadata := data.CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
@@ -0,0 +1,424 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "greenlight"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "http://localhost:4000/callback"
+	testCode         = "auth-code"
+)
+
+// fakeProvider is a minimal OpenID Connect provider serving discovery, a JWKS and a
+// token endpoint that checks the PKCE verifier like a real provider would.
+type fakeProvider struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	keys          map[string]crypto.Signer
+	jwksRequests  int
+	codeChallenge string
+	idToken       string
+	tokenRequest  url.Values
+	clientID      string
+	clientSecret  string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+
+	fp := &fakeProvider{keys: map[string]crypto.Signer{}}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 fp.URL,
+			"authorization_endpoint": fp.URL + "/authorize",
+			"token_endpoint":         fp.URL + "/token",
+			"jwks_uri":               fp.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		fp.mu.Lock()
+		defer fp.mu.Unlock()
+
+		fp.jwksRequests++
+
+		keys := []map[string]string{}
+		for kid, signer := range fp.keys {
+			keys = append(keys, publicJWK(kid, signer.Public()))
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		fp.mu.Lock()
+		defer fp.mu.Unlock()
+
+		err := r.ParseForm()
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+			return
+		}
+
+		fp.tokenRequest = r.PostForm
+		fp.clientID, fp.clientSecret, _ = r.BasicAuth()
+
+		if r.PostForm.Get("code") != testCode || CodeChallenge(r.PostForm.Get("code_verifier")) != fp.codeChallenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": fp.idToken, "token_type": "Bearer"})
+	})
+
+	fp.Server = httptest.NewServer(mux)
+	t.Cleanup(fp.Close)
+
+	return fp
+}
+
+func (fp *fakeProvider) addRSAKey(t *testing.T, kid string) crypto.Signer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp.mu.Lock()
+	fp.keys[kid] = key
+	fp.mu.Unlock()
+
+	return key
+}
+
+func (fp *fakeProvider) addECKey(t *testing.T, kid string) crypto.Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp.mu.Lock()
+	fp.keys[kid] = key
+	fp.mu.Unlock()
+
+	return key
+}
+
+func (fp *fakeProvider) config() Config {
+	return Config{
+		Issuer:       fp.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}
+}
+
+// newTestProvider returns a client for fp whose clock is fixed at now.
+func newTestProvider(fp *fakeProvider, now *time.Time) *Provider {
+	p := NewProvider(fp.config(), fp.Client())
+	p.now = func() time.Time { return *now }
+	return p
+}
+
+func validClaims(issuer, nonce string, now time.Time) map[string]any {
+	return map[string]any{
+		"iss":            issuer,
+		"sub":            "subject-1",
+		"aud":            testClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+}
+
+func TestExchangeSendsCodeVerifier(t *testing.T) {
+	fp := newFakeProvider(t)
+	key := fp.addRSAKey(t, "k1")
+
+	now := time.Now()
+	p := newTestProvider(fp, &now)
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", "the-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+
+	if got := q.Get("code_challenge_method"); got != "S256" {
+		t.Errorf("code_challenge_method: got %q; want %q", got, "S256")
+	}
+
+	// The provider stores the challenge from the authorization request and checks the
+	// verifier against it when the code is exchanged.
+	fp.codeChallenge = q.Get("code_challenge")
+	fp.idToken = sign(t, "RS256", "k1", key, validClaims(fp.URL, "nonce", now))
+
+	token, err := p.Exchange(context.Background(), testCode, "the-verifier", "nonce")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+
+	if token.Subject != "subject-1" || token.Email != "alice@example.com" || !bool(token.EmailVerified) {
+		t.Errorf("unexpected claims %+v", token)
+	}
+
+	if got := fp.tokenRequest.Get("code_verifier"); got != "the-verifier" {
+		t.Errorf("code_verifier: got %q; want %q", got, "the-verifier")
+	}
+
+	if got := fp.tokenRequest.Get("redirect_uri"); got != testRedirectURL {
+		t.Errorf("redirect_uri: got %q; want %q", got, testRedirectURL)
+	}
+
+	if fp.clientID != testClientID || fp.clientSecret != testClientSecret {
+		t.Errorf("client credentials: got %q:%q", fp.clientID, fp.clientSecret)
+	}
+
+	_, err = p.Exchange(context.Background(), testCode, "another-verifier", "nonce")
+	if !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("wrong verifier: got error %v; want %v", err, ErrExchangeFailed)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	fp := newFakeProvider(t)
+	rsaKey := fp.addRSAKey(t, "rsa")
+	ecKey := fp.addECKey(t, "ec")
+
+	now := time.Now()
+	p := newTestProvider(fp, &now)
+
+	tests := []struct {
+		name   string
+		alg    string
+		kid    string
+		key    crypto.Signer
+		modify func(claims map[string]any)
+		valid  bool
+	}{
+		{name: "RS256", alg: "RS256", kid: "rsa", key: rsaKey, valid: true},
+		{name: "ES256", alg: "ES256", kid: "ec", key: ecKey, valid: true},
+		{name: "audience list with azp", alg: "RS256", kid: "rsa", key: rsaKey, valid: true, modify: func(c map[string]any) {
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = testClientID
+		}},
+		{name: "wrong nonce", alg: "RS256", kid: "rsa", key: rsaKey, modify: func(c map[string]any) {
+			c["nonce"] = "other-nonce"
+		}},
+		{name: "missing nonce", alg: "RS256", kid: "rsa", key: rsaKey, modify: func(c map[string]any) {
+			delete(c, "nonce")
+		}},
+		{name: "wrong audience", alg: "RS256", kid: "rsa", key: rsaKey, modify: func(c map[string]any) {
+			c["aud"] = "another-client"
+		}},
+		{name: "audience list without azp", alg: "RS256", kid: "rsa", key: rsaKey, modify: func(c map[string]any) {
+			c["aud"] = []string{testClientID, "other"}
+		}},
+		{name: "wrong issuer", alg: "RS256", kid: "rsa", key: rsaKey, modify: func(c map[string]any) {
+			c["iss"] = "https://evil.example.com"
+		}},
+		{name: "expired", alg: "RS256", kid: "rsa", key: rsaKey, modify: func(c map[string]any) {
+			c["exp"] = now.Add(-2 * time.Minute).Unix()
+		}},
+		{name: "issued in the future", alg: "RS256", kid: "rsa", key: rsaKey, modify: func(c map[string]any) {
+			c["iat"] = now.Add(5 * time.Minute).Unix()
+		}},
+		{name: "algorithm not matching key", alg: "ES256", kid: "rsa", key: rsaKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(fp.URL, "nonce", now)
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+
+			raw := sign(t, tt.alg, tt.kid, tt.key, claims)
+
+			_, err := p.VerifyIDToken(context.Background(), raw, "nonce")
+
+			switch {
+			case tt.valid && err != nil:
+				t.Errorf("got error %v; want valid token", err)
+			case !tt.valid && !errors.Is(err, ErrInvalidIDToken):
+				t.Errorf("got error %v; want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsTamperedToken(t *testing.T) {
+	fp := newFakeProvider(t)
+	key := fp.addRSAKey(t, "k1")
+
+	now := time.Now()
+	p := newTestProvider(fp, &now)
+
+	raw := sign(t, "RS256", "k1", key, validClaims(fp.URL, "nonce", now))
+	forged := sign(t, "RS256", "k1", key, map[string]any{"sub": "someone-else"})
+
+	// Swap in the payload of another token, keeping the original signature.
+	tampered := strings.Join([]string{strings.Split(raw, ".")[0], strings.Split(forged, ".")[1], strings.Split(raw, ".")[2]}, ".")
+
+	_, err := p.VerifyIDToken(context.Background(), tampered, "nonce")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("got error %v; want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestUnknownKeyRefetchesJWKS(t *testing.T) {
+	fp := newFakeProvider(t)
+	oldKey := fp.addRSAKey(t, "old")
+
+	now := time.Now()
+	p := newTestProvider(fp, &now)
+
+	_, err := p.VerifyIDToken(context.Background(), sign(t, "RS256", "old", oldKey, validClaims(fp.URL, "nonce", now)), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fp.jwksRequests != 1 {
+		t.Fatalf("got %d JWKS requests after first token; want 1", fp.jwksRequests)
+	}
+
+	// The provider rotates to a new key. Tokens signed with it have a kid that isn't
+	// in the cached key set yet.
+	newKey := fp.addRSAKey(t, "new")
+
+	now = now.Add(jwksRefreshInterval + time.Second)
+
+	_, err = p.VerifyIDToken(context.Background(), sign(t, "RS256", "new", newKey, validClaims(fp.URL, "nonce", now)), "nonce")
+	if err != nil {
+		t.Fatalf("token signed with rotated key: %v", err)
+	}
+
+	if fp.jwksRequests != 2 {
+		t.Errorf("got %d JWKS requests after key rotation; want 2", fp.jwksRequests)
+	}
+
+	// A kid the provider doesn't know either is refused without another fetch until
+	// the refresh interval has passed.
+	_, err = p.VerifyIDToken(context.Background(), sign(t, "RS256", "unknown", newKey, validClaims(fp.URL, "nonce", now)), "nonce")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("unknown kid: got error %v; want %v", err, ErrInvalidIDToken)
+	}
+
+	if fp.jwksRequests != 2 {
+		t.Errorf("got %d JWKS requests within the refresh interval; want 2", fp.jwksRequests)
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	fp := newFakeProvider(t)
+
+	config := fp.config()
+	config.Issuer = fp.URL + "/"
+
+	p := NewProvider(config, fp.Client())
+
+	_, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil {
+		t.Error("got no error for a discovery document with a different issuer")
+	}
+}
+
+// sign builds a compact JWS with the given header algorithm and key ID.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := encode(header) + "." + encode(payload)
+	digest := sha256.Sum256([]byte(input))
+
+	var signature []byte
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return input + "." + encode(signature)
+}
+
+func publicJWK(kid string, key crypto.PublicKey) map[string]string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   encode(k.N.Bytes()),
+			"e":   encode(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		return map[string]string{
+			"kty": "EC",
+			"kid": kid,
+			"use": "sig",
+			"crv": "P-256",
+			"x":   encode(k.X.FillBytes(make([]byte, 32))),
+			"y":   encode(k.Y.FillBytes(make([]byte, 32))),
+		}
+	default:
+		panic("unsupported key type")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,124 @@
+// Package testdb provides throwaway PostgreSQL databases for tests. Each database is
+// a fresh schema with every migration applied, dropped again when the test ends.
+// Tests using it are skipped unless GREENLIGHT_TEST_DSN names a database they may
+// create schemas in.
+package testdb
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// New returns a connection pool to an empty database with all migrations applied.
+func New(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DSN is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := "test_" + randomHex(t, 8)
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		defer admin.Close()
+
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if err != nil {
+			t.Errorf("dropping schema %s: %v", schema, err)
+		}
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema+",public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrate(t, db)
+
+	return db
+}
+
+// withSearchPath adds a search_path run-time parameter to a DSN in either the URL or
+// the key/value form, so every connection in the pool uses the test schema.
+func withSearchPath(dsn, searchPath string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			q := u.Query()
+			q.Set("search_path", searchPath)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+
+	return dsn + " search_path=" + searchPath
+}
+
+// migrate applies the up migrations in order.
+func migrate(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("cannot locate migrations directory")
+	}
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(file), "..", "..", "migrations", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, f := range files {
+		query, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.ExecContext(ctx, string(query))
+		if err != nil {
+			t.Fatalf("applying %s: %v", filepath.Base(f), err)
+		}
+	}
+}
+
+func randomHex(t *testing.T, n int) string {
+	t.Helper()
+
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(b)
+}
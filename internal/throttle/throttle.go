@@ -0,0 +1,158 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Attempts is the failure history recorded for a single key, such as an email
+// address or a client IP.
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store persists failed attempts. RecordFailure starts counting from one again when
+// the previous failure is older than window.
+type Store interface {
+	Get(key string) (Attempts, error)
+	RecordFailure(key string, window time.Duration) (Attempts, error)
+	Reset(key string) error
+}
+
+// Limiter applies exponential back-off between failed attempts and a temporary
+// lockout once a key reaches the failure threshold.
+type Limiter struct {
+	store       Store
+	threshold   int
+	baseDelay   time.Duration
+	lockout     time.Duration
+	maxBackoffs int
+
+	now func() time.Time
+}
+
+func NewLimiter(store Store, threshold int, baseDelay, lockout time.Duration) *Limiter {
+	return &Limiter{
+		store:     store,
+		threshold: threshold,
+		baseDelay: baseDelay,
+		lockout:   lockout,
+		// Cap the exponent so the back-off can't overflow; by then the lockout
+		// kicks in anyway for any sensible threshold.
+		maxBackoffs: 16,
+		now:         time.Now,
+	}
+}
+
+// Check returns how long the caller has to wait before another attempt for key is
+// allowed, or zero if an attempt may be made now.
+func (l *Limiter) Check(key string) (time.Duration, error) {
+	attempts, err := l.store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	since := l.now().Sub(attempts.LastFailure)
+
+	if attempts.Failures == 0 || since > l.lockout {
+		return 0, nil
+	}
+
+	var wait time.Duration
+
+	if attempts.Failures >= l.threshold {
+		wait = l.lockout
+	} else {
+		wait = l.baseDelay << min(attempts.Failures-1, l.maxBackoffs)
+		wait = min(wait, l.lockout)
+	}
+
+	if since >= wait {
+		return 0, nil
+	}
+
+	return wait - since, nil
+}
+
+// Fail records a failed attempt for key and reports whether this failure is the one
+// that locked the key out.
+func (l *Limiter) Fail(key string) (bool, error) {
+	attempts, err := l.store.RecordFailure(key, l.lockout)
+	if err != nil {
+		return false, err
+	}
+
+	return attempts.Failures == l.threshold, nil
+}
+
+// Succeed clears the failure history of key.
+func (l *Limiter) Succeed(key string) error {
+	return l.store.Reset(key)
+}
+
+// MemoryStore is a Store for a single API instance. Entries are dropped once they are
+// older than the retention period.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+	now      func() time.Time
+}
+
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	s := &MemoryStore{attempts: make(map[string]Attempts), now: time.Now}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			s.mu.Lock()
+
+			for key, attempts := range s.attempts {
+				if s.now().Sub(attempts.LastFailure) > retention {
+					delete(s.attempts, key)
+				}
+			}
+
+			s.mu.Unlock()
+		}
+	}()
+
+	return s
+}
+
+func (s *MemoryStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) RecordFailure(key string, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.attempts[key]
+
+	now := s.now()
+
+	if now.Sub(attempts.LastFailure) > window {
+		attempts.Failures = 0
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+
+	s.attempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+
+	return nil
+}
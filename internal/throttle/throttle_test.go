@@ -0,0 +1,182 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestLimiter returns a limiter over a memory store, both reading the time from
+// the returned clock.
+func newTestLimiter(threshold int, baseDelay, lockout time.Duration) (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	store := NewMemoryStore(lockout)
+	store.now = clock.Now
+
+	limiter := NewLimiter(store, threshold, baseDelay, lockout)
+	limiter.now = clock.Now
+
+	return limiter, clock
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		lockout   time.Duration
+		failures  int
+		elapsed   time.Duration
+		want      time.Duration
+	}{
+		{name: "no failures", threshold: 5, lockout: time.Hour, failures: 0, want: 0},
+		{name: "one failure", threshold: 5, lockout: time.Hour, failures: 1, want: time.Second},
+		{name: "two failures", threshold: 5, lockout: time.Hour, failures: 2, want: 2 * time.Second},
+		{name: "four failures", threshold: 5, lockout: time.Hour, failures: 4, want: 8 * time.Second},
+		{name: "part of the back-off elapsed", threshold: 5, lockout: time.Hour, failures: 4, elapsed: 3 * time.Second, want: 5 * time.Second},
+		{name: "back-off elapsed", threshold: 5, lockout: time.Hour, failures: 4, elapsed: 8 * time.Second, want: 0},
+		{name: "threshold reached", threshold: 5, lockout: time.Hour, failures: 5, want: time.Hour},
+		{name: "part of the lockout elapsed", threshold: 5, lockout: time.Hour, failures: 5, elapsed: 20 * time.Minute, want: 40 * time.Minute},
+		{name: "lockout elapsed", threshold: 5, lockout: time.Hour, failures: 5, elapsed: time.Hour, want: 0},
+		{name: "back-off capped at the lockout", threshold: 10, lockout: 5 * time.Second, failures: 6, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, clock := newTestLimiter(tt.threshold, time.Second, tt.lockout)
+
+			for range tt.failures {
+				_, err := limiter.Fail("key")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			clock.advance(tt.elapsed)
+
+			wait, err := limiter.Check("key")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if wait != tt.want {
+				t.Errorf("got wait %v; want %v", wait, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailReportsLockoutOnce(t *testing.T) {
+	limiter, _ := newTestLimiter(3, time.Second, time.Hour)
+
+	for i, want := range []bool{false, false, true, false} {
+		lockedOut, err := limiter.Fail("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lockedOut != want {
+			t.Errorf("failure %d: got locked out %t; want %t", i+1, lockedOut, want)
+		}
+	}
+}
+
+func TestFailuresResetAfterWindow(t *testing.T) {
+	limiter, clock := newTestLimiter(3, time.Second, time.Hour)
+
+	for range 2 {
+		_, err := limiter.Fail("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failure within the window keeps counting towards the threshold...
+	clock.advance(30 * time.Minute)
+
+	lockedOut, err := limiter.Fail("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !lockedOut {
+		t.Fatal("third failure within the window didn't lock the key out")
+	}
+
+	// ...but once the window has passed since the last one, counting starts over.
+	clock.advance(time.Hour + time.Second)
+
+	lockedOut, err = limiter.Fail("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lockedOut {
+		t.Error("first failure after the window locked the key out")
+	}
+
+	wait, err := limiter.Check("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait != time.Second {
+		t.Errorf("got wait %v after the window reset; want %v", wait, time.Second)
+	}
+}
+
+func TestSucceedClearsFailures(t *testing.T) {
+	limiter, _ := newTestLimiter(3, time.Second, time.Hour)
+
+	for range 3 {
+		_, err := limiter.Fail("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := limiter.Succeed("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait, err := limiter.Check("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait != 0 {
+		t.Errorf("got wait %v after success; want 0", wait)
+	}
+}
+
+func TestKeysAreIndependent(t *testing.T) {
+	limiter, _ := newTestLimiter(3, time.Second, time.Hour)
+
+	for range 3 {
+		_, err := limiter.Fail("email:alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wait, err := limiter.Check("email:bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wait != 0 {
+		t.Errorf("got wait %v for another key; want 0", wait)
+	}
+}
@@ -0,0 +1,163 @@
+package totp
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed from the test vectors in RFC 6238, appendix B.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestGenerateRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; the 6-digit codes are their last six digits.
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := generate(rfc6238Secret, tt.time/Period); got != tt.code {
+			t.Errorf("time %d: got code %s; want %s", tt.time, got, tt.code)
+		}
+
+		step, ok := Validate(rfc6238Secret, tt.code, time.Unix(tt.time, 0))
+		if !ok || step != tt.time/Period {
+			t.Errorf("time %d: Validate returned step %d, %t; want %d, true", tt.time, step, ok, tt.time/Period)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / Period
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"current step", current, true},
+		{"previous step", current - 1, true},
+		{"next step", current + 1, true},
+		{"two steps behind", current - 2, false},
+		{"two steps ahead", current + 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfc6238Secret, generate(rfc6238Secret, tt.step), now)
+			if ok != tt.valid {
+				t.Fatalf("got valid %t; want %t", ok, tt.valid)
+			}
+
+			// The matched step is what callers store to reject a replayed code, so it
+			// must be the step the code was generated for rather than the current one.
+			if ok && step != tt.step {
+				t.Errorf("got step %d; want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	for _, code := range []string{"", "28708", "2870820", "94287082", "abcdef"} {
+		if _, ok := Validate(rfc6238Secret, code, now); ok {
+			t.Errorf("code %q: accepted", code)
+		}
+	}
+
+	if _, ok := Validate([]byte("another secret"), "287082", now); ok {
+		t.Error("code for another secret: accepted")
+	}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := c.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, secret) {
+		t.Error("ciphertext contains the plaintext secret")
+	}
+
+	opened, err := c.Decrypt(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, secret) {
+		t.Errorf("got secret %x; want %x", opened, secret)
+	}
+
+	// A fresh nonce is used every time, so sealing the same secret twice differs.
+	again, err := c.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(again, sealed) {
+		t.Error("sealing the same secret twice gave the same ciphertext")
+	}
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		tampered := bytes.Clone(sealed)
+		tampered[len(tampered)-1] ^= 1
+
+		_, err := c.Decrypt(tampered)
+		if !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("got error %v; want %v", err, ErrInvalidCiphertext)
+		}
+	})
+
+	t.Run("truncated ciphertext", func(t *testing.T) {
+		_, err := c.Decrypt(sealed[:4])
+		if !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("got error %v; want %v", err, ErrInvalidCiphertext)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		other, err := NewCipher(bytes.Repeat([]byte{2}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = other.Decrypt(sealed)
+		if !errors.Is(err, ErrInvalidCiphertext) {
+			t.Errorf("got error %v; want %v", err, ErrInvalidCiphertext)
+		}
+	})
+}
+
+func TestNewCipherRequires32ByteKey(t *testing.T) {
+	for _, size := range []int{0, 16, 24, 31, 33} {
+		_, err := NewCipher(make([]byte, size))
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%d byte key: got error %v; want %v", size, err, ErrInvalidKey)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    archive bytea NOT NULL
+);
@@ -0,0 +1,4 @@
+DELETE FROM permissions WHERE code = 'users:admin';
+
+ALTER TABLE users DROP COLUMN IF EXISTS suspension_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS watched;
+DROP TABLE IF EXISTS watchlist;
@@ -0,0 +1,7 @@
+INSERT INTO permissions (code)
+VALUES ('collections:moderate');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name IN ('moderator', 'admin') AND permissions.code = 'collections:moderate';